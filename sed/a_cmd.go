@@ -26,7 +26,6 @@
 package sed
 
 import (
-	"bytes"
 	"fmt"
 )
 
@@ -35,8 +34,8 @@ type a_cmd struct {
 	text []byte
 }
 
-func (c *a_cmd) match(line []byte, lineNumber int) bool {
-	return c.addr.match(line, lineNumber)
+func (c *a_cmd) match(s *Sed) bool {
+	return c.addr.match(s)
 }
 
 func (c *a_cmd) String() string {
@@ -50,24 +49,14 @@ func (c *a_cmd) String() string {
 }
 
 func (c *a_cmd) processLine(s *Sed) (bool, error) {
+	// the text is written out at the end of the cycle
+	s.appendQueue = append(s.appendQueue, appended{text: append(copyByteSlice(c.text), '\n')})
 	return false, nil
 }
 
-func NewACmd(s *Sed, line []byte, addr *address) (*a_cmd, error) {
+func NewACmd(text []byte, addr *address) (*a_cmd, error) {
 	cmd := new(a_cmd)
 	cmd.addr = addr
-	cmd.text = line[1:]
-	for bytes.HasSuffix(cmd.text, []byte{'\\'}) {
-		cmd.text = cmd.text[0 : len(cmd.text)-1]
-		line, err := s.getNextScriptLine()
-		if err != nil {
-			break
-		}
-		buf := bytes.NewBuffer(cmd.text)
-		buf.WriteRune('\n')
-		buf.Write(line)
-		cmd.text = buf.Bytes()
-	}
-	cmd.text = trimSpaceFromBeginning(cmd.text)
+	cmd.text = text
 	return cmd, nil
 }
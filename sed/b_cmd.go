@@ -26,17 +26,18 @@
 package sed
 
 import (
-	"bytes"
 	"fmt"
 )
 
 type b_cmd struct {
-	addr  *address
-	label string
+	addr      *address
+	label     string
+	target    int
+	scriptPos int
 }
 
-func (c *b_cmd) match(line []byte, lineNumber int) bool {
-	return c.addr.match(line, lineNumber)
+func (c *b_cmd) match(s *Sed) bool {
+	return c.addr.match(s)
 }
 
 func (c *b_cmd) String() string {
@@ -50,15 +51,13 @@ func (c *b_cmd) String() string {
 }
 
 func (c *b_cmd) processLine(s *Sed) (bool, error) {
-	return true, NotImplemented
+	s.pc = c.target
+	return false, nil
 }
 
-func NewBCmd(pieces [][]byte, addr *address) (*b_cmd, error) {
-	if len(pieces) != 1 {
-		return nil, WrongNumberOfCommandParameters
-	}
+func NewBCmd(label []byte, addr *address) (*b_cmd, error) {
 	cmd := new(b_cmd)
 	cmd.addr = addr
-	cmd.label = string(bytes.TrimSpace(pieces[0][1:]))
+	cmd.label = string(label)
 	return cmd, nil
 }
@@ -0,0 +1,443 @@
+//
+//  backtrack.go
+//  sed
+//
+// Copyright (c) 2009 Geoffrey Clements
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+
+package sed
+
+import (
+	"bytes"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxBacktrackDepth is how deep the backtracker may nest its calls, which
+// grows with the length of a match, before it gives up rather than run out
+// of stack.
+const maxBacktrackDepth = 100000
+
+// backtracker matches a regular expression by trying every way through its
+// tree, which is slow but, unlike Go's regexp package, can match a back
+// reference or the start or end of a word. To find the longest match at a
+// position it has to try them all, so a search gives up after
+// -backtrack-limit steps rather than run for ever on a pathological regular
+// expression. Positions where no match can start are skipped with Go's
+// regexp package first, so they don't use up the steps.
+type backtracker struct {
+	src        string
+	tree       *reNode
+	groups     int
+	ignoreCase bool
+	multiline  bool
+	prefilter  *regexp.Regexp
+
+	// the state of one search
+	text    []byte
+	caps    []int
+	best    []int
+	steps   int
+	depth   int
+	limited bool
+}
+
+func newBacktracker(src string, tree *reNode, groups int, flags int) *backtracker {
+	return &backtracker{
+		src:        src,
+		tree:       tree,
+		groups:     groups,
+		ignoreCase: flags&reIgnoreCase != 0,
+		multiline:  flags&reMultiline != 0,
+		prefilter:  newPrefilter(tree, flags),
+	}
+}
+
+// newPrefilter returns a Go regular expression that matches wherever n
+// could, and more: back references match anything and anchors are left
+// out, as they depend on the text before the start of the search. Its
+// repeats are lazy, the shortest match is enough to say a match starts
+// there. It returns nil if Go can't compile it.
+func newPrefilter(n *reNode, flags int) *regexp.Regexp {
+	buf := bytes.NewBufferString("(?sU")
+	if flags&reIgnoreCase != 0 {
+		buf.WriteByte('i')
+	}
+	buf.WriteByte(')')
+	n.superset().writeGo(buf)
+	re, err := regexp.Compile(buf.String())
+	if err != nil {
+		return nil
+	}
+	return re
+}
+
+// superset returns a copy of n that Go's regexp package can match, which
+// matches everything n matches without looking at the text around it.
+func (n *reNode) superset() *reNode {
+	switch n.op {
+	case reBackRef:
+		return &reNode{op: reRepeat, min: 0, max: -1, subs: []*reNode{{op: reAnyChar}}}
+	case reLineStart, reLineEnd, reTextStart, reTextEnd,
+		reWordBoundary, reNotWordBoundary, reWordStart, reWordEnd:
+		return &reNode{op: reConcat}
+	}
+	c := *n
+	if c.op == reRepeat {
+		// too many to count for Go, but these match more
+		if c.min > goRepeatMax {
+			c.min = goRepeatMax
+		}
+		if c.max > goRepeatMax {
+			c.max = -1
+		}
+	}
+	c.subs = make([]*reNode, len(n.subs))
+	for i, sub := range n.subs {
+		c.subs[i] = sub.superset()
+	}
+	return &c
+}
+
+func (b *backtracker) String() string {
+	return b.src
+}
+
+func (b *backtracker) numSubexp() int {
+	return b.groups
+}
+
+func (b *backtracker) match(text []byte) (bool, error) {
+	m, err := b.findSubmatchIndex(text)
+	return m != nil, err
+}
+
+func (b *backtracker) findSubmatchIndex(text []byte) ([]int, error) {
+	return b.findAt(text, 0)
+}
+
+// findAllSubmatchIndex finds successive matches that don't overlap. Like
+// Go's regexp package it ignores an empty match right after another match,
+// and stops after n matches unless n is negative.
+func (b *backtracker) findAllSubmatchIndex(text []byte, n int) ([][]int, error) {
+	var matches [][]int
+	prevEnd := -1
+	for pos := 0; pos <= len(text) && len(matches) != n; {
+		m, err := b.findAt(text, pos)
+		if err != nil || m == nil {
+			return matches, err
+		}
+		if m[0] == m[1] && m[0] == prevEnd {
+			// step over the empty match and look again
+			pos = m[0] + runeWidth(text, m[0])
+			continue
+		}
+		matches = append(matches, m)
+		prevEnd = m[1]
+		pos = m[1]
+		if m[0] == m[1] {
+			pos += runeWidth(text, pos)
+		}
+	}
+	return matches, nil
+}
+
+// findAt finds the leftmost longest match starting at or after pos. The
+// text before pos is still there for ^ and \b to look at.
+func (b *backtracker) findAt(text []byte, pos int) ([]int, error) {
+	b.text = text
+	b.steps = 0
+	b.depth = 0
+	b.limited = false
+	defer func() { b.text = nil }()
+	b.caps = make([]int, 2*(b.groups+1))
+	for start := pos; start <= len(text); start += runeWidth(text, start) {
+		if b.prefilter != nil {
+			// skip to where a match might start
+			loc := b.prefilter.FindIndex(text[start:])
+			if loc == nil {
+				break
+			}
+			start += loc[0]
+		}
+		for i := range b.caps {
+			b.caps[i] = -1
+		}
+		b.best = nil
+		b.try(b.tree, start, func(end int) bool {
+			if b.best == nil || end > b.best[1] {
+				b.best = append(b.best[:0], b.caps...)
+				b.best[0], b.best[1] = start, end
+			}
+			// nothing can be longer than a match to the end
+			return end == len(text)
+		})
+		if b.limited {
+			return nil, BacktrackLimitExceeded
+		}
+		if b.best != nil {
+			return b.best, nil
+		}
+		if start == len(text) {
+			break
+		}
+	}
+	return nil, nil
+}
+
+func runeWidth(text []byte, pos int) int {
+	if pos >= len(text) {
+		return 1
+	}
+	_, width := utf8.DecodeRune(text[pos:])
+	return width
+}
+
+// try matches n at pos and then calls next with the position after the
+// match, for every way n can match, until next returns true. It returns
+// whether next did.
+func (b *backtracker) try(n *reNode, pos int, next func(int) bool) bool {
+	b.steps++
+	b.depth++
+	if b.steps > *backtrack_limit || b.depth > maxBacktrackDepth {
+		b.limited = true
+	}
+	if b.limited {
+		return true
+	}
+	matched := b.tryNode(n, pos, next)
+	b.depth--
+	return matched
+}
+
+func (b *backtracker) tryNode(n *reNode, pos int, next func(int) bool) bool {
+	switch n.op {
+	case reLiteral, reAnyChar, reClass:
+		if pos >= len(b.text) {
+			return false
+		}
+		r, width := utf8.DecodeRune(b.text[pos:])
+		switch {
+		case n.op == reLiteral && !b.sameRune(r, n.r):
+			return false
+		case n.op == reClass && !n.class.matches(r, b.ignoreCase):
+			return false
+		}
+		return next(pos + width)
+	case reLineStart:
+		return (pos == 0 || b.multiline && b.text[pos-1] == '\n') && next(pos)
+	case reLineEnd:
+		return (pos == len(b.text) || b.multiline && b.text[pos] == '\n') && next(pos)
+	case reTextStart:
+		return pos == 0 && next(pos)
+	case reTextEnd:
+		return pos == len(b.text) && next(pos)
+	case reWordBoundary, reNotWordBoundary:
+		boundary := b.isWordAt(pos-1) != b.isWordAt(pos)
+		return boundary == (n.op == reWordBoundary) && next(pos)
+	case reWordStart:
+		return !b.isWordAt(pos-1) && b.isWordAt(pos) && next(pos)
+	case reWordEnd:
+		return b.isWordAt(pos-1) && !b.isWordAt(pos) && next(pos)
+	case reGroup:
+		start, end := b.caps[2*n.group], b.caps[2*n.group+1]
+		if b.try(n.subs[0], pos, func(p int) bool {
+			oldStart, oldEnd := b.caps[2*n.group], b.caps[2*n.group+1]
+			b.caps[2*n.group], b.caps[2*n.group+1] = pos, p
+			if next(p) {
+				return true
+			}
+			b.caps[2*n.group], b.caps[2*n.group+1] = oldStart, oldEnd
+			return false
+		}) {
+			return true
+		}
+		b.caps[2*n.group], b.caps[2*n.group+1] = start, end
+		return false
+	case reConcat:
+		return b.trySeq(n.subs, pos, next)
+	case reAlternate:
+		for _, sub := range n.subs {
+			if b.try(sub, pos, next) {
+				return true
+			}
+		}
+		return false
+	case reRepeat:
+		return b.tryRepeat(n, 0, pos, next)
+	case reBackRef:
+		start, end := b.caps[2*n.group], b.caps[2*n.group+1]
+		if start < 0 {
+			// a group that didn't match can't be matched again
+			return false
+		}
+		length := end - start
+		if pos+length > len(b.text) {
+			return false
+		}
+		// comparing a long group is work too
+		b.steps += length
+		if b.ignoreCase && !bytes.EqualFold(b.text[pos:pos+length], b.text[start:end]) ||
+			!b.ignoreCase && !bytes.Equal(b.text[pos:pos+length], b.text[start:end]) {
+			return false
+		}
+		return next(pos + length)
+	}
+	return false
+}
+
+func (b *backtracker) trySeq(subs []*reNode, pos int, next func(int) bool) bool {
+	if len(subs) == 0 {
+		return next(pos)
+	}
+	return b.try(subs[0], pos, func(p int) bool {
+		return b.trySeq(subs[1:], p, next)
+	})
+}
+
+// tryRepeat matches the rest of a repeat that has matched count times so
+// far, trying more repeats before fewer. A repeat that matches nothing
+// once the minimum is reached does so once, setting its groups, but doesn't
+// go round again.
+func (b *backtracker) tryRepeat(n *reNode, count, pos int, next func(int) bool) bool {
+	if sub := n.subs[0]; sub.op == reLiteral || sub.op == reAnyChar || sub.op == reClass {
+		return b.tryRuneRepeat(n, count, pos, next)
+	}
+	if n.max < 0 || count < n.max {
+		if b.try(n.subs[0], pos, func(p int) bool {
+			if p == pos && count >= n.min {
+				return next(p)
+			}
+			return b.tryRepeat(n, count+1, p, next)
+		}) {
+			return true
+		}
+	}
+	return count >= n.min && next(pos)
+}
+
+// tryRuneRepeat is tryRepeat for a repeat of a single character, which
+// finds every end it can reach first rather than nesting a call for each
+// character, so a long match doesn't go deep.
+func (b *backtracker) tryRuneRepeat(n *reNode, count, pos int, next func(int) bool) bool {
+	ends := []int{pos}
+	for p := pos; (n.max < 0 || count < n.max) && b.try(n.subs[0], p, func(e int) bool {
+		p = e
+		return true
+	}); count++ {
+		if b.limited {
+			return true
+		}
+		ends = append(ends, p)
+	}
+	for i := len(ends) - 1; i >= 0 && count >= n.min; i-- {
+		if next(ends[i]) {
+			return true
+		}
+		count--
+	}
+	return false
+}
+
+// sameRune reports whether x and y are the same, or when ignoring case,
+// the same but for case.
+func (b *backtracker) sameRune(x, y rune) bool {
+	if x == y {
+		return true
+	}
+	if !b.ignoreCase {
+		return false
+	}
+	for f := unicode.SimpleFold(x); f != x; f = unicode.SimpleFold(f) {
+		if f == y {
+			return true
+		}
+	}
+	return false
+}
+
+// isWordAt reports whether the byte at pos is a word character, the same
+// ASCII ones \w matches in Go's regexp package.
+func (b *backtracker) isWordAt(pos int) bool {
+	if pos < 0 || pos >= len(b.text) {
+		return false
+	}
+	c := b.text[pos]
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// matches reports whether r is in the bracket expression, or with
+// ignoreCase, whether r in any case is. The named classes are the ASCII
+// ones, as in Go's regexp package.
+func (br *reBracket) matches(r rune, ignoreCase bool) bool {
+	in := br.contains(r)
+	if ignoreCase {
+		for f := unicode.SimpleFold(r); f != r && !in; f = unicode.SimpleFold(f) {
+			in = br.contains(f)
+		}
+	}
+	return in != br.negate
+}
+
+func (br *reBracket) contains(r rune) bool {
+	for _, rng := range br.ranges {
+		if rng[0] <= r && r <= rng[1] {
+			return true
+		}
+	}
+	for _, name := range br.classes {
+		if inClass(name, r) {
+			return true
+		}
+	}
+	return false
+}
+
+func inClass(name string, r rune) bool {
+	switch name {
+	case "alnum":
+		return inClass("alpha", r) || inClass("digit", r)
+	case "alpha":
+		return inClass("lower", r) || inClass("upper", r)
+	case "blank":
+		return r == ' ' || r == '\t'
+	case "cntrl":
+		return r < ' ' || r == 0x7f
+	case "digit":
+		return '0' <= r && r <= '9'
+	case "graph":
+		return '!' <= r && r <= '~'
+	case "lower":
+		return 'a' <= r && r <= 'z'
+	case "print":
+		return ' ' <= r && r <= '~'
+	case "punct":
+		return inClass("graph", r) && !inClass("alnum", r)
+	case "space":
+		return r == ' ' || '\t' <= r && r <= '\r'
+	case "upper":
+		return 'A' <= r && r <= 'Z'
+	case "xdigit":
+		return inClass("digit", r) || 'a' <= r && r <= 'f' || 'A' <= r && r <= 'F'
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+//
+//  block_cmd.go
+//  sed
+//
+// Copyright (c) 2009 Geoffrey Clements
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+
+package sed
+
+import (
+	"fmt"
+)
+
+type block_cmd struct {
+	addr      *address
+	end       int
+	scriptPos int
+}
+
+// the address of a block is checked in processLine so the block can be
+// skipped when it doesn't match
+func (c *block_cmd) match(s *Sed) bool {
+	return true
+}
+
+func (c *block_cmd) String() string {
+	if c != nil {
+		if c.addr != nil {
+			return fmt.Sprintf("{{ command addr:%s end:%d}", c.addr.String(), c.end)
+		}
+		return fmt.Sprintf("{{ command end:%d}", c.end)
+	}
+	return fmt.Sprint("{{ command}")
+}
+
+func (c *block_cmd) processLine(s *Sed) (bool, error) {
+	if !c.addr.match(s) {
+		// jump past the closing }
+		s.pc = c.end
+	}
+	return false, nil
+}
+
+func NewBlockCmd(addr *address) (*block_cmd, error) {
+	cmd := new(block_cmd)
+	cmd.addr = addr
+	return cmd, nil
+}
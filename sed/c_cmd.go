@@ -26,7 +26,6 @@
 package sed
 
 import (
-	"bytes"
 	"fmt"
 )
 
@@ -35,8 +34,8 @@ type c_cmd struct {
 	text []byte
 }
 
-func (c *c_cmd) match(line []byte, lineNumber int) bool {
-	return c.addr.match(line, lineNumber)
+func (c *c_cmd) match(s *Sed) bool {
+	return c.addr.match(s)
 }
 
 func (c *c_cmd) String() string {
@@ -49,47 +48,22 @@ func (c *c_cmd) String() string {
 	return fmt.Sprintf("{c command}")
 }
 
-func (c *c_cmd) printText(s *Sed) {
-	// we are going to get the newline from the
-	s.outputFile.Write(c.text)
-}
-
+// processLine deletes the pattern space and starts the next cycle. The text
+// is printed in its place, except that a range is changed as a whole, with
+// the text printed once when its end matches. A range the input ends inside
+// is just deleted. A negated range is changed a line at a time.
 func (c *c_cmd) processLine(s *Sed) (bool, error) {
 	s.patternSpace = s.patternSpace[0:0]
-	if c.addr != nil {
-		switch c.addr.address_type {
-		case ADDRESS_RANGE:
-			if s.lineNumber+1 == c.addr.rangeEnd {
-				c.printText(s)
-				return true, nil
-			}
-		case ADDRESS_LINE, ADDRESS_REGEX, ADDRESS_LAST_LINE:
-			c.printText(s)
-			return true, nil
-		case ADDRESS_TO_END_OF_FILE:
-			// FIX need to output at end of file
-		}
-	} else {
-		c.printText(s)
+	if c.addr != nil && c.addr.address_type == ADDRESS_RANGE && !c.addr.not && c.addr.active {
 		return true, nil
 	}
-	return false, nil
+	s.printText(c.text)
+	return true, nil
 }
 
-func NewCCmd(s *Sed, line []byte, addr *address) (*c_cmd, error) {
+func NewCCmd(text []byte, addr *address) (*c_cmd, error) {
 	cmd := new(c_cmd)
 	cmd.addr = addr
-	cmd.text = line[1:]
-	for bytes.HasSuffix(cmd.text, []byte{'\\'}) {
-		cmd.text = cmd.text[0 : len(cmd.text)-1]
-		line, err := s.getNextScriptLine()
-		if err != nil {
-			break
-		}
-		buf := bytes.NewBuffer(cmd.text)
-		buf.WriteRune('\n')
-		buf.Write(line)
-		cmd.text = buf.Bytes()
-	}
+	cmd.text = text
 	return cmd, nil
 }
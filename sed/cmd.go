@@ -26,46 +26,74 @@
 package sed
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"regexp"
-	"strconv"
 )
 
 var (
 	WrongNumberOfCommandParameters error = errors.New("Wrong number of parameters for command")
 	UnknownScriptCommand           error = errors.New("Unknown script command")
 	InvalidSCommandFlag            error = errors.New("Invalid flag for s command")
+	RepeatedSCommandFlag           error = errors.New("Flag given more than once to an s command")
+	ZeroSCommandOccurrence         error = errors.New("The number flag of an s command can't be zero")
+	MissingFilename                error = errors.New("Expected a file name")
+	MissingText                    error = errors.New("Expected \\ or text after a, c or i")
+	InvalidLineZero                error = errors.New("Line 0 can only start a range ending in a regular expression")
+	NoSedForFile                   error = errors.New("A command that writes a file needs a Sed to open it")
 	RegularExpressionExpected      error = errors.New("Expected a regular expression, got zero length string")
 	UnterminatedRegularExpression  error = errors.New("Unterminated regular expression")
 	NoSupportForTwoAddress         error = errors.New("This command doesn't support an address range or to end of file")
 	NotImplemented                 error = errors.New("This command command hasn't been implemented yet")
+	MissingLabel                   error = errors.New("Expected a label after :")
+	LabelWithAddress               error = errors.New("A label can't have an address")
+	DuplicateLabel                 error = errors.New("Label defined more than once")
+	UndefinedLabel                 error = errors.New("Branch to an undefined label")
+	UnexpectedBlockEnd             error = errors.New("Unexpected } without a matching {")
+	UnterminatedBlock              error = errors.New("Unmatched { without a closing }")
+	YStringsDifferentLength        error = errors.New("The strings for y have a different number of characters")
+	InvalidReference               error = errors.New("Invalid reference on the right hand side of an s command")
+	UnmatchedParen                 error = errors.New("Unmatched ( or \\(")
+	UnmatchedCloseParen            error = errors.New("Unmatched ) or \\)")
+	UnmatchedBrace                 error = errors.New("Unmatched \\{")
+	UnmatchedBracket               error = errors.New("Unmatched [, [^, [:, [., or [=")
+	InvalidInterval                error = errors.New("Invalid content of \\{\\}")
+	InvalidPrecedingRegex          error = errors.New("Invalid preceding regular expression")
+	InvalidBackReference           error = errors.New("Invalid back reference")
+	InvalidCharacterClass          error = errors.New("Invalid character class name")
+	InvalidCollationCharacter      error = errors.New("Invalid collation character")
+	InvalidRangeEnd                error = errors.New("Invalid range end")
+	TrailingBackslash              error = errors.New("Trailing backslash")
+	BacktrackLimitExceeded         error = errors.New("Regular expression took too many steps to match, see -backtrack-limit")
 )
 
+type Address interface {
+	match(s *Sed) bool
+}
+
 type Cmd interface {
 	fmt.Stringer
+	Address
 	processLine(s *Sed) (stop bool, err error)
 }
 
-type Address interface {
-	match(line []byte, lineNumber int) bool
-}
-
 const (
 	ADDRESS_LINE = iota
 	ADDRESS_RANGE
-	ADDRESS_TO_END_OF_FILE
 	ADDRESS_LAST_LINE
 	ADDRESS_REGEX
 )
 
+// An address is a single line number, $ or regular expression, or a range
+// between two of them. A range remembers whether it is active so it can
+// find its end on a later line.
 type address struct {
 	not          bool
 	address_type int
-	rangeStart   int
-	rangeEnd     int
-	regex        *regexp.Regexp
+	line         int
+	regex        matcher
+	start, end   *address
+	active       bool
+	closed       bool
 }
 
 func (a *address) getTypeAsString() string {
@@ -75,8 +103,6 @@ func (a *address) getTypeAsString() string {
 			return "ADDRESS_LINE"
 		case ADDRESS_RANGE:
 			return "ADDRESS_RANGE"
-		case ADDRESS_TO_END_OF_FILE:
-			return "ADDRESS_TO_END_OF_FILE"
 		case ADDRESS_LAST_LINE:
 			return "ADDRESS_LAST_LINE"
 		case ADDRESS_REGEX:
@@ -89,25 +115,20 @@ func (a *address) getTypeAsString() string {
 }
 
 func (a *address) String() string {
-	return fmt.Sprintf("address{type: %s rangeStart:%d rangeEnd:%d regex:%v}", a.getTypeAsString(), a.rangeStart, a.rangeEnd, a.regex)
+	if a.address_type == ADDRESS_RANGE {
+		return fmt.Sprintf("address{type: %s start:%s end:%s not:%v}", a.getTypeAsString(), a.start, a.end, a.not)
+	}
+	return fmt.Sprintf("address{type: %s line:%d regex:%v not:%v}", a.getTypeAsString(), a.line, a.regex, a.not)
 }
 
-func (a *address) match(line []byte, lineNumber int) bool {
+func (a *address) match(s *Sed) bool {
 	val := true
 	if a != nil {
 		switch a.address_type {
-		case ADDRESS_LINE:
-			val = lineNumber == a.rangeStart
 		case ADDRESS_RANGE:
-			val = lineNumber >= a.rangeStart && lineNumber <= a.rangeEnd
-		case ADDRESS_TO_END_OF_FILE:
-			val = lineNumber >= a.rangeStart
-		case ADDRESS_LAST_LINE:
-			val = false // this is wrong!
-		case ADDRESS_REGEX:
-			val = a.regex.Match(line)
+			val = a.matchRange(s)
 		default:
-			val = false
+			val = a.matchLine(s)
 		}
 		if a.not {
 			val = !val
@@ -116,125 +137,274 @@ func (a *address) match(line []byte, lineNumber int) bool {
 	return val
 }
 
-func getNumberFromLine(s []byte) ([]byte, int, error) {
-	idx := 0
-	for {
-		if s[idx] < '0' || s[idx] > '9' {
-			break
+// matchLine matches a single address against the current line.
+func (a *address) matchLine(s *Sed) bool {
+	switch a.address_type {
+	case ADDRESS_LINE:
+		return s.lineNumber == a.line
+	case ADDRESS_LAST_LINE:
+		return s.isLastLine()
+	case ADDRESS_REGEX:
+		matched, err := a.regex.match(s.patternSpace)
+		if err != nil {
+			s.regexErr = err
 		}
-		idx++
+		return matched
 	}
-	i, err := strconv.Atoi(string(s[0:idx]))
-	if err != nil {
-		return s, -1, err
+	return false
+}
+
+// matchRange steps the range through its states. An inactive range starts
+// when its first address matches. The end isn't checked on that same line
+// unless it is a line number at or before the current line, or $ on the
+// last line, in which case the range is only the one line. An active range
+// includes every line up to and including the one that matches its end,
+// after which it can start again. A range starting at a line number is
+// different, it starts on the first line at or after that number the
+// command sees, and only once. 0,/re/ starts before the first line, so its
+// end is checked there.
+func (a *address) matchRange(s *Sed) bool {
+	if !a.active {
+		if a.start.address_type == ADDRESS_LINE {
+			if a.closed || s.lineNumber < a.start.line {
+				return false
+			}
+		} else if !a.start.matchLine(s) {
+			return false
+		}
+		switch a.end.address_type {
+		case ADDRESS_LINE:
+			a.active = a.end.line > s.lineNumber
+		case ADDRESS_LAST_LINE:
+			a.active = !s.isLastLine()
+		default:
+			// only a range from line 0 can end on the line it starts
+			a.active = a.start.address_type != ADDRESS_LINE || a.start.line != 0 || !a.end.matchLine(s)
+		}
+		a.closed = !a.active
+		return true
 	}
-	return s[idx:], i, nil
+	if a.end.address_type == ADDRESS_LINE {
+		// a range ending on a line number ends there even if the command
+		// wasn't run for that line
+		if s.lineNumber >= a.end.line {
+			a.active = false
+			a.closed = true
+		}
+		return s.lineNumber <= a.end.line
+	}
+	if a.end.matchLine(s) {
+		a.active = false
+		a.closed = true
+	}
+	return true
 }
 
 // A nil address means match any line
-func checkForAddress(s []byte) ([]byte, *address, error) {
-	var err error
-	if s[0] == '/' {
-		// regular expression address
-		s = s[1:]
-		idx := bytes.IndexByte(s, '/')
-		if idx < 0 {
-			return s, nil, UnterminatedRegularExpression
-		}
-		r := s[0:idx]
+func checkForAddress(lx *scriptLexer) (*address, error) {
+	start := lx.pos
+	addr, err := readAddress(lx)
+	if addr == nil || err != nil {
+		return nil, err
+	}
+	// there is no line 0, but 0,/re/ is a range that can end on line 1
+	lineZero := addr.address_type == ADDRESS_LINE && addr.line == 0
+	lx.skipBlanks()
+	if lx.peek() == ',' {
+		lx.next()
+		lx.skipBlanks()
+		end, err := readAddress(lx)
+		if err != nil {
+			return nil, err
+		}
+		if end == nil {
+			// without a second address the range runs to end of file
+			end = new(address)
+			end.address_type = ADDRESS_LAST_LINE
+		}
+		if lineZero && end.address_type != ADDRESS_REGEX {
+			return nil, lx.errorAt(InvalidLineZero, start)
+		}
+		r := new(address)
+		r.address_type = ADDRESS_RANGE
+		r.start = addr
+		r.end = end
+		addr = r
+	} else if lineZero {
+		return nil, lx.errorAt(InvalidLineZero, start)
+	}
+	return checkForNot(lx, addr)
+}
+
+// readAddress reads a single line number, $ or regular expression address.
+func readAddress(lx *scriptLexer) (*address, error) {
+	start := lx.pos
+	switch c := lx.peek(); {
+	case c == '/' || c == '\\':
+		// regular expression address, \cREGEXc uses c as the delimiter
+		lx.next()
+		delim := byte('/')
+		if c == '\\' {
+			delim = lx.next()
+			if delim == 0 || delim == '\n' || delim == '\\' {
+				return nil, lx.errorAt(UnterminatedRegularExpression, start)
+			}
+		}
+		r, err := lx.readDelimited(delim, true)
+		if err != nil {
+			return nil, err
+		}
 		if len(r) == 0 {
-			return s, nil, RegularExpressionExpected
+			return nil, lx.errorAt(RegularExpressionExpected, start)
 		}
-		// s is now just the command
-		s = s[idx+1:]
 		addr := new(address)
 		addr.address_type = ADDRESS_REGEX
-		addr.regex, err = regexp.Compile(string(r))
+		addr.regex, err = compileRegex(r, 0)
 		if err != nil {
-			return s, nil, err
+			return nil, lx.errorAt(err, start)
 		}
-		return s, addr, nil
-	} else if s[0] == '$' {
+		return addr, nil
+	case c == '$':
 		// end of file
+		lx.next()
 		addr := new(address)
 		addr.address_type = ADDRESS_LAST_LINE
-		// s is now just the command
-		s = s[1:]
-		return s, addr, nil
-	} else if s[0] >= '0' && s[0] <= '9' {
+		return addr, nil
+	case c >= '0' && c <= '9':
 		// numeric line address
 		addr := new(address)
 		addr.address_type = ADDRESS_LINE
-		s, addr.rangeStart, err = getNumberFromLine(s)
-		if err != nil {
-			return s, nil, err
-		}
-		addr.rangeEnd = addr.rangeStart
-		if s[0] == ',' {
-			s = s[1:]
-			if len(s) > 0 && s[0] >= '0' && s[0] <= '9' {
-				addr.address_type = ADDRESS_RANGE
-				s, addr.rangeEnd, err = getNumberFromLine(s)
-				if err != nil {
-					return s, nil, err
-				}
-				// if end range is less than start only match single line
-				if addr.rangeEnd < addr.rangeStart {
-					addr.address_type = ADDRESS_LINE
-					addr.rangeEnd = 0
-				}
-			} else {
-				addr.address_type = ADDRESS_TO_END_OF_FILE
-			}
-		}
-		if s[0] == '!' {
-			addr.not = true
-			s = s[1:]
-		}
-		return s, addr, nil
+		addr.line, _ = lx.readNumber()
+		return addr, nil
+	}
+	return nil, nil
+}
+
+// checkForNot looks for a ! after an address, which negates it
+func checkForNot(lx *scriptLexer, addr *address) (*address, error) {
+	lx.skipBlanks()
+	if lx.peek() == '!' {
+		lx.next()
+		addr.not = true
+		lx.skipBlanks()
 	}
-	return s, nil, nil
+	return addr, nil
 }
 
+// NewCmd parses a single command, with its address, from line.
 func NewCmd(s *Sed, line []byte) (Cmd, error) {
+	c, err := parseCmd(s, newScriptLexer(line))
+	var serr *scriptError
+	if errors.As(err, &serr) {
+		// there is only one line so the position isn't interesting
+		err = serr.err
+	}
+	return c, err
+}
 
-	var err error
-	var addr *address
-	line, addr, err = checkForAddress(line)
+// parseCmd parses the command, with its address, at the lexer's position
+// and everything after it up to the start of the next command.
+func parseCmd(s *Sed, lx *scriptLexer) (Cmd, error) {
+	addr, err := checkForAddress(lx)
 	if err != nil {
 		return nil, err
 	}
-
-	if len(line) > 0 {
-		switch line[0] {
-		case 'a':
-			return NewACmd(s, line, addr)
-		case 'b':
-			return NewBCmd(bytes.Split(line, []byte{'/'}), addr)
-		case 'c':
-			return NewCCmd(s, line, addr)
-		case 'd', 'D':
-			return NewDCmd(bytes.Split(line, []byte{'/'}), addr)
-		case 'g', 'G':
-			return NewGCmd(bytes.Split(line, []byte{'/'}), addr)
-		case 'h', 'H':
-			return NewHCmd(bytes.Split(line, []byte{'/'}), addr)
-		case 'i':
-			return NewICmd(s, line, addr)
-		case 'n':
-			return NewNCmd(bytes.Split(line, []byte{'/'}), addr)
-		case 'P', 'p':
-			return NewPCmd(bytes.Split(line, []byte{'/'}), addr)
-		case 'q':
-			return NewQCmd(bytes.Split(line, []byte{'/'}), addr)
-		case 'r':
-			return NewRCmd(line, addr)
-		case 's':
-			return NewSCmd(bytes.Split(line, []byte{'/'}), addr)
-		case '=':
-			return NewEqlCmd(bytes.Split(line, []byte{'/'}), addr)
-		}
-	}
-
-	return nil, UnknownScriptCommand
+	lx.skipBlanks()
+	start := lx.pos
+	var c Cmd
+	name := lx.next()
+	// an error in an argument is reported where the argument starts, or
+	// where in it the constructor says
+	argPos := lx.pos
+	switch name {
+	case '{':
+		// the first command of the block can follow directly
+		return NewBlockCmd(addr)
+	case ':':
+		c, err = NewColonCmd(lx.readLabel(), addr)
+	case 'a':
+		var text []byte
+		if text, err = lx.readText(); err != nil {
+			return nil, err
+		}
+		c, err = NewACmd(text, addr)
+	case 'b':
+		c, err = NewBCmd(lx.readLabel(), addr)
+	case 'c':
+		var text []byte
+		if text, err = lx.readText(); err != nil {
+			return nil, err
+		}
+		c, err = NewCCmd(text, addr)
+	case 'd', 'D':
+		c, err = NewDCmd(name, addr)
+	case 'g', 'G':
+		c, err = NewGCmd(name, addr)
+	case 'h', 'H':
+		c, err = NewHCmd(name, addr)
+	case 'i':
+		var text []byte
+		if text, err = lx.readText(); err != nil {
+			return nil, err
+		}
+		c, err = NewICmd(text, addr)
+	case 'l':
+		c, err = NewLCmd(lx.readDigits(), addr)
+	case 'n', 'N':
+		c, err = NewNCmd(name, addr)
+	case 'P', 'p':
+		c, err = NewPCmd(name, addr)
+	case 'q', 'Q':
+		c, err = NewQCmd(name, lx.readDigits(), addr)
+	case 'r', 'R':
+		c, err = NewRCmd(name, lx.readFilename(), addr)
+	case 's':
+		var regex, replace []byte
+		regex, replace, err = lx.readPair(true)
+		if err != nil {
+			return nil, err
+		}
+		var sc *s_cmd
+		flagsPos := lx.pos
+		sc, err = NewSCmd(regex, replace, lx.readSFlags(), addr)
+		if errors.As(err, new(*argError)) {
+			argPos = flagsPos
+		}
+		if err == nil && sc.wfilename != nil {
+			sc.wfile, err = s.openWFile(sc.wfilename)
+		}
+		c = sc
+	case 't', 'T':
+		c, err = NewTCmd(name, lx.readLabel(), addr)
+	case 'w', 'W':
+		var file *wfile
+		file, err = s.openWFile(lx.readFilename())
+		if err == nil {
+			c, err = NewWCmd(name, file, addr)
+		}
+	case 'x':
+		c, err = NewXCmd(addr)
+	case 'y':
+		var source, dest []byte
+		source, dest, err = lx.readPair(false)
+		if err != nil {
+			return nil, err
+		}
+		c, err = NewYCmd(source, dest, addr)
+	case '=':
+		c, err = NewEqlCmd(addr)
+	default:
+		return nil, lx.errorAt(UnknownScriptCommand, start)
+	}
+	if err != nil {
+		var aerr *argError
+		if errors.As(err, &aerr) {
+			return nil, lx.errorAt(aerr.err, argPos+aerr.offset)
+		}
+		return nil, lx.errorAt(err, argPos)
+	}
+	if err = lx.endCommand(WrongNumberOfCommandParameters); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
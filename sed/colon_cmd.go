@@ -0,0 +1,62 @@
+//
+//  colon_cmd.go
+//  sed
+//
+// Copyright (c) 2009 Geoffrey Clements
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+
+package sed
+
+import (
+	"fmt"
+)
+
+type colon_cmd struct {
+	label string
+}
+
+func (c *colon_cmd) match(s *Sed) bool {
+	return true
+}
+
+func (c *colon_cmd) String() string {
+	if c != nil {
+		return fmt.Sprintf("{: command label: %s}", c.label)
+	}
+	return fmt.Sprint("{: command}")
+}
+
+func (c *colon_cmd) processLine(s *Sed) (bool, error) {
+	// labels are only branch targets, there is nothing to do
+	return false, nil
+}
+
+func NewColonCmd(label []byte, addr *address) (*colon_cmd, error) {
+	if addr != nil {
+		return nil, LabelWithAddress
+	}
+	cmd := new(colon_cmd)
+	cmd.label = string(label)
+	if len(cmd.label) == 0 {
+		return nil, MissingLabel
+	}
+	return cmd, nil
+}
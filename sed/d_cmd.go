@@ -35,8 +35,8 @@ type d_cmd struct {
 	upToFirstNewLine bool
 }
 
-func (c *d_cmd) match(line []byte, lineNumber int) bool {
-	return c.addr.match(line, lineNumber)
+func (c *d_cmd) match(s *Sed) bool {
+	return c.addr.match(s)
 }
 
 func (c *d_cmd) String() string {
@@ -48,21 +48,20 @@ func (c *d_cmd) String() string {
 
 func (c *d_cmd) processLine(s *Sed) (bool, error) {
 	if c.upToFirstNewLine {
+		// D restarts the cycle with what is left of the pattern space,
+		// even if that is nothing, without a newline it acts like d
 		idx := bytes.IndexByte(s.patternSpace, '\n')
-		if idx >= 0 && idx+1 < len(s.patternSpace) {
+		if idx >= 0 {
 			s.patternSpace = s.patternSpace[idx+1:]
-			return false, nil
+			s.restart = true
 		}
 	}
 	return true, nil
 }
 
-func NewDCmd(pieces [][]byte, addr *address) (*d_cmd, error) {
-	if len(pieces) > 1 {
-		return nil, WrongNumberOfCommandParameters
-	}
+func NewDCmd(name byte, addr *address) (*d_cmd, error) {
 	cmd := new(d_cmd)
-	if pieces[0][0] == 'D' {
+	if name == 'D' {
 		cmd.upToFirstNewLine = true
 	}
 	cmd.addr = addr
@@ -27,15 +27,15 @@ package sed
 
 import (
 	"fmt"
-	"os"
+	"strconv"
 )
 
 type eql_cmd struct {
 	addr *address
 }
 
-func (c *eql_cmd) match(line []byte, lineNumber int) bool {
-	return c.addr.match(line, lineNumber)
+func (c *eql_cmd) match(s *Sed) bool {
+	return c.addr.match(s)
 }
 
 func (c *eql_cmd) String() string {
@@ -46,14 +46,11 @@ func (c *eql_cmd) String() string {
 }
 
 func (c *eql_cmd) processLine(s *Sed) (bool, error) {
-	fmt.Fprintf(os.Stdout, "\n%d\n", s.lineNumber)
+	s.printText([]byte(strconv.Itoa(s.lineNumber)))
 	return false, nil
 }
 
-func NewEqlCmd(pieces [][]byte, addr *address) (*eql_cmd, error) {
-	if len(pieces) > 1 {
-		return nil, WrongNumberOfCommandParameters
-	}
+func NewEqlCmd(addr *address) (*eql_cmd, error) {
 	cmd := new(eql_cmd)
 	cmd.addr = addr
 	return cmd, nil
@@ -35,17 +35,17 @@ type g_cmd struct {
 	replace bool
 }
 
-func (c *g_cmd) match(line []byte, lineNumber int) bool {
-	return c.addr.match(line, lineNumber)
+func (c *g_cmd) match(s *Sed) bool {
+	return c.addr.match(s)
 }
 
 func (c *g_cmd) String() string {
 	if c != nil {
 		if c.addr != nil {
 			if c.replace {
-				return fmt.Sprint("{g command with replace addr:%s}", c.addr.String())
+				return fmt.Sprintf("{g command with replace addr:%s}", c.addr.String())
 			} else {
-				return fmt.Sprint("{g command addr:%s}", c.addr.String())
+				return fmt.Sprintf("{g command addr:%s}", c.addr.String())
 			}
 		} else {
 			if c.replace {
@@ -70,12 +70,9 @@ func (c *g_cmd) processLine(s *Sed) (bool, error) {
 	return false, nil
 }
 
-func NewGCmd(pieces [][]byte, addr *address) (*g_cmd, error) {
-	if len(pieces) > 1 {
-		return nil, WrongNumberOfCommandParameters
-	}
+func NewGCmd(name byte, addr *address) (*g_cmd, error) {
 	cmd := new(g_cmd)
-	if pieces[0][0] == 'g' {
+	if name == 'g' {
 		cmd.replace = true
 	}
 	cmd.addr = addr
@@ -35,23 +35,23 @@ type h_cmd struct {
 	replace bool
 }
 
-func (c *h_cmd) match(line []byte, lineNumber int) bool {
-	return c.addr.match(line, lineNumber)
+func (c *h_cmd) match(s *Sed) bool {
+	return c.addr.match(s)
 }
 
 func (c *h_cmd) String() string {
 	if c != nil {
 		if c.addr != nil {
 			if c.replace {
-				return fmt.Sprint("{h command with replace addr:%s}", c.addr.String())
+				return fmt.Sprintf("{h command with replace addr:%s}", c.addr.String())
 			} else {
-				return fmt.Sprint("{h command Cmd addr:%s}", c.addr.String())
+				return fmt.Sprintf("{h command Cmd addr:%s}", c.addr.String())
 			}
 		} else {
 			if c.replace {
 				return fmt.Sprint("{h command with replace }")
 			} else {
-				return fmt.Sprint("{h command")
+				return fmt.Sprint("{h command}")
 			}
 		}
 	}
@@ -62,20 +62,17 @@ func (c *h_cmd) processLine(s *Sed) (bool, error) {
 	if c.replace {
 		s.holdSpace = copyByteSlice(s.patternSpace)
 	} else {
-		buf := bytes.NewBuffer(s.patternSpace)
+		buf := bytes.NewBuffer(s.holdSpace)
 		buf.WriteRune('\n')
-		buf.Write(s.holdSpace)
-		s.patternSpace = buf.Bytes()
+		buf.Write(s.patternSpace)
+		s.holdSpace = buf.Bytes()
 	}
 	return false, nil
 }
 
-func NewHCmd(pieces [][]byte, addr *address) (*h_cmd, error) {
-	if len(pieces) > 1 {
-		return nil, WrongNumberOfCommandParameters
-	}
+func NewHCmd(name byte, addr *address) (*h_cmd, error) {
 	cmd := new(h_cmd)
-	if pieces[0][0] == 'h' {
+	if name == 'h' {
 		cmd.replace = true
 	}
 	cmd.addr = addr
@@ -26,7 +26,6 @@
 package sed
 
 import (
-	"bytes"
 	"fmt"
 )
 
@@ -35,8 +34,8 @@ type i_cmd struct {
 	text []byte
 }
 
-func (c *i_cmd) match(line []byte, lineNumber int) bool {
-	return c.addr.match(line, lineNumber)
+func (c *i_cmd) match(s *Sed) bool {
+	return c.addr.match(s)
 }
 
 func (c *i_cmd) String() string {
@@ -50,23 +49,13 @@ func (c *i_cmd) String() string {
 }
 
 func (c *i_cmd) processLine(s *Sed) (bool, error) {
+	s.printText(c.text)
 	return false, nil
 }
 
-func NewICmd(s *Sed, line []byte, addr *address) (*i_cmd, error) {
+func NewICmd(text []byte, addr *address) (*i_cmd, error) {
 	cmd := new(i_cmd)
 	cmd.addr = addr
-	cmd.text = line[1:]
-	for bytes.HasSuffix(cmd.text, []byte{'\\'}) {
-		cmd.text = cmd.text[0 : len(cmd.text)-1]
-		line, err := s.getNextScriptLine()
-		if err != nil {
-			break
-		}
-		// cmd.text = bytes.AddByte(cmd.text, '\n')
-		buf := bytes.NewBuffer(cmd.text)
-		buf.Write(line)
-		s.patternSpace = buf.Bytes()
-	}
+	cmd.text = text
 	return cmd, nil
 }
@@ -0,0 +1,118 @@
+//
+//  l_cmd.go
+//  sed
+//
+// Copyright (c) 2009 Geoffrey Clements
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+
+package sed
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+type l_cmd struct {
+	addr *address
+	// width is the line wrap length given to the command, or -1 to use -l
+	width int
+}
+
+func (c *l_cmd) match(s *Sed) bool {
+	return c.addr.match(s)
+}
+
+func (c *l_cmd) String() string {
+	if c != nil && c.addr != nil {
+		return fmt.Sprintf("{l command addr:%s width:%d}", c.addr.String(), c.width)
+	}
+	return fmt.Sprint("{l command}")
+}
+
+func NewLCmd(width []byte, addr *address) (c *l_cmd, err error) {
+	c = new(l_cmd)
+	c.addr = addr
+	c.width = -1
+	if len(width) > 0 {
+		c.width, err = strconv.Atoi(string(width))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *l_cmd) processLine(s *Sed) (bool, error) {
+	width := c.width
+	if width < 0 {
+		width = int(*line_wrap)
+	}
+	s.printText(listLine(s.patternSpace, width))
+	return false, nil
+}
+
+// listLine returns line the way the l command shows it. Backslash and the
+// control characters with a C escape are written as that escape, other
+// bytes that aren't printable ASCII as a \ and three octal digits, and the
+// end of the line is marked with a $. If width is more than 1, the output
+// is broken into lines of at most width characters, each ending in a \
+// and never splitting an escape.
+func listLine(line []byte, width int) []byte {
+	var out bytes.Buffer
+	column := 0
+	for _, b := range line {
+		var esc string
+		switch b {
+		case '\\':
+			esc = `\\`
+		case '\a':
+			esc = `\a`
+		case '\b':
+			esc = `\b`
+		case '\f':
+			esc = `\f`
+		case '\n':
+			esc = `\n`
+		case '\r':
+			esc = `\r`
+		case '\t':
+			esc = `\t`
+		case '\v':
+			esc = `\v`
+		default:
+			if b < ' ' || b > '~' {
+				esc = fmt.Sprintf(`\%03o`, b)
+			} else {
+				esc = string(b)
+			}
+		}
+		// leave room for the \ at the end of a wrapped line
+		if width > 1 && column+len(esc) > width-1 {
+			out.WriteString("\\\n")
+			column = 0
+		}
+		out.WriteString(esc)
+		column += len(esc)
+	}
+	out.WriteByte('$')
+	return out.Bytes()
+}
@@ -0,0 +1,365 @@
+//
+//  lexer.go
+//  sed
+//
+// Copyright (c) 2009 Geoffrey Clements
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+
+package sed
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+// scriptError is an error found while parsing a script along with the line
+// and column where it was found.
+type scriptError struct {
+	err    error
+	line   int
+	column int
+	text   []byte
+}
+
+func (e *scriptError) Error() string {
+	return fmt.Sprintf("%s -> %d:%d: %s", e.err.Error(), e.line, e.column, e.text)
+}
+
+func (e *scriptError) Unwrap() error {
+	return e.err
+}
+
+// argError is an error a command constructor found offset bytes into one
+// of its arguments, so the parser can say where.
+type argError struct {
+	err    error
+	offset int
+}
+
+func (e *argError) Error() string {
+	return e.err.Error()
+}
+
+func (e *argError) Unwrap() error {
+	return e.err
+}
+
+// scriptLexer walks a script buffer a byte at a time. It knows how each kind
+// of command argument is delimited so that a ; or / inside a regular
+// expression, a replacement or a text argument isn't mistaken for the end of
+// a command.
+type scriptLexer struct {
+	buf []byte
+	pos int
+}
+
+func newScriptLexer(buf []byte) *scriptLexer {
+	lx := new(scriptLexer)
+	lx.buf = buf
+	return lx
+}
+
+func (lx *scriptLexer) eof() bool {
+	return lx.pos >= len(lx.buf)
+}
+
+// peek returns the next byte without consuming it, or 0 at the end of the
+// script.
+func (lx *scriptLexer) peek() byte {
+	if lx.eof() {
+		return 0
+	}
+	return lx.buf[lx.pos]
+}
+
+func (lx *scriptLexer) next() byte {
+	b := lx.peek()
+	if !lx.eof() {
+		lx.pos++
+	}
+	return b
+}
+
+// skipBlanks skips spaces and tabs but not newlines, which end commands.
+func (lx *scriptLexer) skipBlanks() {
+	for !lx.eof() && (lx.buf[lx.pos] == ' ' || lx.buf[lx.pos] == '\t') {
+		lx.pos++
+	}
+}
+
+// skipSpace skips all white space including newlines.
+func (lx *scriptLexer) skipSpace() {
+	for !lx.eof() && isSpace(lx.buf[lx.pos]) {
+		lx.pos++
+	}
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
+
+// atCommandEnd reports whether the lexer is at something that may follow a
+// command: a ;, a newline, a } closing a block, a comment or the end of the
+// script.
+func (lx *scriptLexer) atCommandEnd() bool {
+	switch lx.peek() {
+	case 0, ';', '\n', '}', '#':
+		return true
+	}
+	return false
+}
+
+// endCommand checks that nothing but blanks follows a command and consumes a
+// trailing ;. A newline, } or comment is left for the caller.
+func (lx *scriptLexer) endCommand(err error) error {
+	lx.skipBlanks()
+	if !lx.atCommandEnd() {
+		return lx.error(err)
+	}
+	if lx.peek() == ';' {
+		lx.next()
+	}
+	return nil
+}
+
+// error wraps err with the position of the lexer.
+func (lx *scriptLexer) error(err error) error {
+	return lx.errorAt(err, lx.pos)
+}
+
+// errorAt wraps err with the line and column of the byte at pos.
+func (lx *scriptLexer) errorAt(err error, pos int) error {
+	if pos > len(lx.buf) {
+		pos = len(lx.buf)
+	}
+	start := bytes.LastIndexByte(lx.buf[0:pos], '\n') + 1
+	end := bytes.IndexByte(lx.buf[start:], '\n')
+	if end < 0 {
+		end = len(lx.buf)
+	} else {
+		end += start
+	}
+	return &scriptError{
+		err:    err,
+		line:   bytes.Count(lx.buf[0:start], newLine) + 1,
+		column: utf8.RuneCount(lx.buf[start:pos]) + 1,
+		text:   lx.buf[start:end],
+	}
+}
+
+// readNumber reads a decimal number. ok is false if there are no digits.
+func (lx *scriptLexer) readNumber() (n int, ok bool) {
+	for !lx.eof() && lx.buf[lx.pos] >= '0' && lx.buf[lx.pos] <= '9' {
+		n = n*10 + int(lx.buf[lx.pos]-'0')
+		lx.pos++
+		ok = true
+	}
+	return n, ok
+}
+
+// readDelimited reads up to the next unescaped delim and consumes the
+// delimiter. An escaped delimiter becomes the delimiter itself, every other
+// escape is left for the regular expression or replacement to deal with. In
+// a regular expression a delimiter inside a bracket expression doesn't end
+// it.
+func (lx *scriptLexer) readDelimited(delim byte, regex bool) ([]byte, error) {
+	start := lx.pos
+	buf := new(bytes.Buffer)
+	for !lx.eof() {
+		b := lx.next()
+		switch {
+		case b == delim:
+			return buf.Bytes(), nil
+		case b == '\n':
+			// an unescaped newline can't be part of an argument
+			return nil, lx.errorAt(UnterminatedRegularExpression, start)
+		case b == '\\':
+			if lx.eof() {
+				return nil, lx.errorAt(UnterminatedRegularExpression, start)
+			}
+			e := lx.next()
+			switch e {
+			case delim:
+				// in a replacement a \& stays escaped so it isn't taken for
+				// the whole match
+				if delim == '&' && !regex {
+					buf.WriteByte('\\')
+				}
+				buf.WriteByte(e)
+			case '\n':
+				// an escaped newline is a literal newline
+				buf.WriteString(`\n`)
+			default:
+				buf.WriteByte('\\')
+				buf.WriteByte(e)
+			}
+		case b == '[' && regex:
+			buf.WriteByte(b)
+			if err := lx.readBracket(buf, start); err != nil {
+				return nil, err
+			}
+		default:
+			buf.WriteByte(b)
+		}
+	}
+	return nil, lx.errorAt(UnterminatedRegularExpression, start)
+}
+
+// readPair reads the two arguments of an s or y command. The first character
+// is the delimiter, which also ends both arguments.
+func (lx *scriptLexer) readPair(regex bool) ([]byte, []byte, error) {
+	start := lx.pos
+	delim := lx.next()
+	if delim == 0 || delim == '\n' || delim == '\\' {
+		return nil, nil, lx.errorAt(UnterminatedRegularExpression, start)
+	}
+	first, err := lx.readDelimited(delim, regex)
+	if err != nil {
+		return nil, nil, err
+	}
+	second, err := lx.readDelimited(delim, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return first, second, nil
+}
+
+// readBracket copies a bracket expression, whose opening [ has already been
+// read, into buf. A ] first in the list, or inside [:class:], [.x.] or [=x=],
+// doesn't close it.
+func (lx *scriptLexer) readBracket(buf *bytes.Buffer, start int) error {
+	if lx.peek() == '^' {
+		buf.WriteByte(lx.next())
+	}
+	if lx.peek() == ']' {
+		buf.WriteByte(lx.next())
+	}
+	for !lx.eof() {
+		b := lx.next()
+		switch {
+		case b == ']':
+			buf.WriteByte(b)
+			return nil
+		case b == '\n':
+			return lx.errorAt(UnterminatedRegularExpression, start)
+		case b == '[' && (lx.peek() == ':' || lx.peek() == '.' || lx.peek() == '='):
+			kind := lx.next()
+			buf.WriteByte(b)
+			buf.WriteByte(kind)
+			end := bytes.Index(lx.buf[lx.pos:], []byte{kind, ']'})
+			if end < 0 || bytes.IndexByte(lx.buf[lx.pos:lx.pos+end], '\n') >= 0 {
+				return lx.errorAt(UnterminatedRegularExpression, start)
+			}
+			buf.Write(lx.buf[lx.pos : lx.pos+end+2])
+			lx.pos += end + 2
+		default:
+			buf.WriteByte(b)
+		}
+	}
+	return lx.errorAt(UnterminatedRegularExpression, start)
+}
+
+// readLabel reads the label of a :, b, t or T command. A label ends at a
+// newline, ; or the } closing a block, surrounding blanks are dropped.
+func (lx *scriptLexer) readLabel() []byte {
+	lx.skipBlanks()
+	start := lx.pos
+	for !lx.eof() && lx.buf[lx.pos] != '\n' && lx.buf[lx.pos] != ';' && lx.buf[lx.pos] != '}' {
+		lx.pos++
+	}
+	return bytes.TrimRight(lx.buf[start:lx.pos], " \t")
+}
+
+// readDigits reads an optional numeric argument, such as the exit code of q.
+func (lx *scriptLexer) readDigits() []byte {
+	lx.skipBlanks()
+	start := lx.pos
+	lx.readNumber()
+	return lx.buf[start:lx.pos]
+}
+
+// readSFlags reads the flags of an s command. They end with the command,
+// unless there is a w flag, whose file name runs to the end of the line.
+func (lx *scriptLexer) readSFlags() []byte {
+	lx.skipBlanks()
+	start := lx.pos
+	for !lx.atCommandEnd() {
+		if lx.next() == 'w' {
+			lx.readFilename()
+			return lx.buf[start:lx.pos]
+		}
+	}
+	return bytes.TrimRight(lx.buf[start:lx.pos], " \t")
+}
+
+// readFilename reads the filename of an r or w command, which runs to the end
+// of the line.
+func (lx *scriptLexer) readFilename() []byte {
+	lx.skipBlanks()
+	start := lx.pos
+	for !lx.eof() && lx.buf[lx.pos] != '\n' {
+		lx.pos++
+	}
+	return lx.buf[start:lx.pos]
+}
+
+// readText reads the text argument of an a, i or c command. The POSIX form
+// is a \ followed by a newline with the text on the following lines, the
+// one line form has the text after the command. Either way a line ending in
+// \ continues the text on the next line and a \ before any other character
+// is removed. The one line form needs some text.
+func (lx *scriptLexer) readText() ([]byte, error) {
+	lx.skipBlanks()
+	if lx.eof() || lx.peek() == '\n' {
+		return nil, lx.error(MissingText)
+	}
+	if lx.peek() == '\\' {
+		lx.next()
+		// blanks after the \ are part of the text unless a newline follows
+		pos := lx.pos
+		lx.skipBlanks()
+		if lx.peek() == '\n' {
+			lx.next()
+		} else {
+			lx.pos = pos
+		}
+	}
+	buf := new(bytes.Buffer)
+	for !lx.eof() && lx.buf[lx.pos] != '\n' {
+		b := lx.next()
+		if b == '\\' {
+			if lx.eof() {
+				break
+			}
+			b = lx.next()
+		}
+		buf.WriteByte(b)
+	}
+	return buf.Bytes(), nil
+}
+
+// skipComment skips a comment up to the end of the line.
+func (lx *scriptLexer) skipComment() {
+	for !lx.eof() && lx.buf[lx.pos] != '\n' {
+		lx.pos++
+	}
+}
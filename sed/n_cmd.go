@@ -30,32 +30,45 @@ import (
 )
 
 type n_cmd struct {
-	addr *address
+	addr       *address
+	appendNext bool
 }
 
-func (c *n_cmd) match(line []byte, lineNumber int) bool {
-	return c.addr.match(line, lineNumber)
+func (c *n_cmd) match(s *Sed) bool {
+	return c.addr.match(s)
 }
 
 func (c *n_cmd) String() string {
+	name := 'n'
+	if c != nil && c.appendNext {
+		name = 'N'
+	}
 	if c != nil && c.addr != nil {
-		return fmt.Sprint("{n command addr:%s}", c.addr.String())
+		return fmt.Sprintf("{%c command addr:%s}", name, c.addr.String())
 	}
-	return fmt.Sprint("{n command}")
+	return fmt.Sprintf("{%c command}", name)
 }
 
 func (c *n_cmd) processLine(s *Sed) (bool, error) {
-	if !*quiet {
+	if s.isLastLine() {
+		// there is no next line so the script ends here. GNU sed prints the
+		// pattern space for N as well as n, POSIX says N doesn't
+		if c.appendNext && *posix {
+			return true, nil
+		}
+		s.pc = len(s.program)
+		return false, nil
+	}
+	if !c.appendNext && !*quiet {
 		s.printPatternSpace()
 	}
-	return true, nil
+	s.readLine(c.appendNext)
+	return false, nil
 }
 
-func NewNCmd(pieces [][]byte, addr *address) (*n_cmd, error) {
-	if len(pieces) > 1 {
-		return nil, WrongNumberOfCommandParameters
-	}
+func NewNCmd(name byte, addr *address) (*n_cmd, error) {
 	cmd := new(n_cmd)
 	cmd.addr = addr
+	cmd.appendNext = name == 'N'
 	return cmd, nil
 }
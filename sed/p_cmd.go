@@ -35,8 +35,8 @@ type p_cmd struct {
 	upToNewLine bool
 }
 
-func (c *p_cmd) match(line []byte, lineNumber int) bool {
-	return c.addr.match(line, lineNumber)
+func (c *p_cmd) match(s *Sed) bool {
+	return c.addr.match(s)
 }
 
 func (c *p_cmd) String() string {
@@ -48,21 +48,18 @@ func (c *p_cmd) String() string {
 
 func (c *p_cmd) processLine(s *Sed) (bool, error) {
 	// print output space
-	if c.upToNewLine {
-		firstLine := bytes.SplitN(s.patternSpace, []byte{'\n'}, 1)[0]
-		fmt.Fprintln(s.outputFile, string(firstLine))
+	idx := bytes.IndexByte(s.patternSpace, '\n')
+	if c.upToNewLine && idx >= 0 {
+		s.printText(s.patternSpace[0:idx])
 	} else {
-		fmt.Fprintln(s.outputFile, string(s.patternSpace))
+		s.printPatternSpace()
 	}
 	return false, nil
 }
 
-func NewPCmd(pieces [][]byte, addr *address) (*p_cmd, error) {
-	if len(pieces) > 1 {
-		return nil, WrongNumberOfCommandParameters
-	}
+func NewPCmd(name byte, addr *address) (*p_cmd, error) {
 	cmd := new(p_cmd)
 	cmd.addr = addr
-	cmd.upToNewLine = pieces[0][0] == 'P'
+	cmd.upToNewLine = name == 'P'
 	return cmd, nil
 }
@@ -27,51 +27,55 @@ package sed
 
 import (
 	"fmt"
-	"os"
 	"strconv"
 )
 
+// quitStatus is how a q or Q command asked sed to stop at the end of the
+// cycle: with the exit code to use, and for q, printing the pattern space
+// and any appended text first.
+type quitStatus struct {
+	exitCode int
+	print    bool
+}
+
 type q_cmd struct {
 	addr      *address
 	exit_code int
+	print     bool
 }
 
-func (c *q_cmd) match(line []byte, lineNumber int) bool {
-	return c.addr.match(line, lineNumber)
+func (c *q_cmd) match(s *Sed) bool {
+	return c.addr.match(s)
 }
 
 func (c *q_cmd) String() string {
 	if c != nil {
+		name := "Q"
+		if c.print {
+			name = "q"
+		}
 		if c.addr != nil {
-			return fmt.Sprintf("{q command addr:%s with exit code: %d}", c.addr.String(), c.exit_code)
+			return fmt.Sprintf("{%s command addr:%s with exit code: %d}", name, c.addr.String(), c.exit_code)
 		}
-		return fmt.Sprintf("{q command with exit code: %d}", c.exit_code)
+		return fmt.Sprintf("{%s command with exit code: %d}", name, c.exit_code)
 	}
 	return fmt.Sprint("{q command}")
 }
 
-func NewQCmd(pieces [][]byte, addr *address) (c *q_cmd, err error) {
-	err = nil
-	c = nil
-	switch len(pieces) {
-	case 2:
-		c = new(q_cmd)
-		c.addr = addr
-		c.exit_code, err = strconv.Atoi(string(pieces[1]))
+func NewQCmd(name byte, exitCode []byte, addr *address) (c *q_cmd, err error) {
+	c = new(q_cmd)
+	c.addr = addr
+	c.print = name == 'q'
+	if len(exitCode) > 0 {
+		c.exit_code, err = strconv.Atoi(string(exitCode))
 		if err != nil {
-			c = nil
+			return nil, err
 		}
-	case 1:
-		c = new(q_cmd)
-		c.addr = addr
-		c.exit_code = 0
-	default:
-		c, err = nil, WrongNumberOfCommandParameters
 	}
-	return c, err
+	return c, nil
 }
 
 func (c *q_cmd) processLine(s *Sed) (stop bool, err error) {
-	os.Exit(c.exit_code)
-	return false, nil
+	s.quit = &quitStatus{exitCode: c.exit_code, print: c.print}
+	return true, nil
 }
@@ -26,41 +26,64 @@
 package sed
 
 import (
+	"bufio"
 	"fmt"
+	"os"
 )
 
 type r_cmd struct {
-	addr *address
-	text []byte
+	addr     *address
+	filename string
+	oneLine  bool
 }
 
-func (c *r_cmd) match(line []byte, lineNumber int) bool {
-	return c.addr.match(line, lineNumber)
+func (c *r_cmd) match(s *Sed) bool {
+	return c.addr.match(s)
 }
 
 func (c *r_cmd) String() string {
+	name := "r"
+	if c != nil && c.oneLine {
+		name = "R"
+	}
 	if c != nil && c.addr != nil {
-		return fmt.Sprintf("{r command addr:%s}", c.addr.String())
+		return fmt.Sprintf("{%s command filename:%s addr:%s}", name, c.filename, c.addr.String())
 	}
-	return fmt.Sprint("{r command}")
+	return fmt.Sprintf("{%s command}", name)
 }
 
+// processLine queues the file, or for R its next line, to be written out at
+// the end of the cycle. A file that can't be read is quietly ignored.
 func (c *r_cmd) processLine(s *Sed) (bool, error) {
-	// print output space
-	if c.text != nil {
-		s.outputFile.Write(c.text)
+	if !c.oneLine {
+		// the file is read when it is written out
+		s.appendQueue = append(s.appendQueue, appended{filename: c.filename})
+		return false, nil
+	}
+	r, ok := s.rfiles[c.filename]
+	if !ok {
+		// every R of a file shares one reader, so they read successive lines
+		if f, err := os.Open(c.filename); err == nil {
+			r = bufio.NewReader(f)
+		}
+		s.rfiles[c.filename] = r
+	}
+	if r != nil {
+		line, _ := r.ReadBytes('\n')
+		if len(line) > 0 {
+			s.appendQueue = append(s.appendQueue, appended{text: line})
+		}
 	}
 	return false, nil
 }
 
-func NewRCmd(line []byte, addr *address) (*r_cmd, error) {
-	line = line[1:]
+func NewRCmd(name byte, filename []byte, addr *address) (*r_cmd, error) {
+	if len(filename) == 0 {
+		return nil, MissingFilename
+	}
 	cmd := new(r_cmd)
 	cmd.addr = addr
-	if len(line) > 0 {
-		cmd.text = line
-	} else {
-		cmd.text = nil
-	}
+	cmd.filename = string(filename)
+	cmd.oneLine = name == 'R'
 	return cmd, nil
 }
@@ -0,0 +1,100 @@
+//
+//  reader.go
+//  sed
+//
+// Copyright (c) 2009 Geoffrey Clements
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+
+package sed
+
+import (
+	"bufio"
+	"io"
+)
+
+// lineReader reads lines from a sequence of readers as if they were one
+// stream. It reads one line ahead so it can tell when the line it just
+// returned is the last one. Lines can be any length and the last line of a
+// reader doesn't need to end with a newline.
+type lineReader struct {
+	readers            []*bufio.Reader
+	next               []byte
+	hasNext            bool
+	nextMissingNewLine bool
+	missingNewLine     bool
+	err                error
+}
+
+func newLineReader(readers ...io.Reader) *lineReader {
+	r := new(lineReader)
+	for _, reader := range readers {
+		r.readers = append(r.readers, bufio.NewReader(reader))
+	}
+	r.fill()
+	return r
+}
+
+// fill reads the lookahead line, moving on to the next reader when one runs
+// out. A read error stops all input, it is kept in r.err.
+func (r *lineReader) fill() {
+	r.next = nil
+	r.hasNext = false
+	for len(r.readers) > 0 {
+		line, err := r.readers[0].ReadBytes('\n')
+		if err == nil {
+			r.next = line[0 : len(line)-1]
+			r.hasNext = true
+			r.nextMissingNewLine = false
+			return
+		}
+		if err != io.EOF {
+			r.err = err
+			r.readers = nil
+			return
+		}
+		r.readers = r.readers[1:]
+		if len(line) > 0 {
+			// the last line of this reader has no newline
+			r.next = line
+			r.hasNext = true
+			r.nextMissingNewLine = true
+			return
+		}
+	}
+}
+
+// readLine returns the next line without its newline. ok is false when there
+// are no more lines, or reading failed.
+func (r *lineReader) readLine() (line []byte, ok bool) {
+	if !r.hasNext {
+		return nil, false
+	}
+	line = r.next
+	r.missingNewLine = r.nextMissingNewLine
+	r.fill()
+	return line, true
+}
+
+// isLast reports whether the line most recently returned by readLine is the
+// last line of the input.
+func (r *lineReader) isLast() bool {
+	return !r.hasNext
+}
@@ -0,0 +1,771 @@
+//
+//  regex.go
+//  sed
+//
+// Copyright (c) 2009 Geoffrey Clements
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+
+package sed
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// sed's regular expressions are POSIX ones, which Go's regexp package
+// doesn't understand. They are parsed here into a tree of reNodes which is
+// then written out again in the syntax Go's regexp package uses.
+
+type reOp int
+
+const (
+	reLiteral         reOp = iota // the rune r
+	reAnyChar                     // .
+	reClass                       // a bracket expression, \w or \s
+	reLineStart                   // ^
+	reLineEnd                     // $
+	reTextStart                   // \`
+	reTextEnd                     // \'
+	reWordBoundary                // \b
+	reNotWordBoundary             // \B
+	reWordStart                   // \<
+	reWordEnd                     // \>
+	reGroup                       // \( \), subs[0] is group number group
+	reConcat                      // subs one after another
+	reAlternate                   // any one of subs
+	reRepeat                      // subs[0] from min to max times, max -1 for no limit
+	reBackRef                     // \1 to \9
+)
+
+type reNode struct {
+	op       reOp
+	r        rune
+	class    *reBracket
+	subs     []*reNode
+	min, max int
+	group    int
+}
+
+// reBracket is a bracket expression. It matches a rune in one of ranges or
+// in one of the named character classes, or with negate set, a rune in none
+// of them.
+type reBracket struct {
+	negate  bool
+	ranges  [][2]rune
+	classes []string
+}
+
+var reClassNames = map[string]bool{
+	"alnum": true, "alpha": true, "blank": true, "cntrl": true,
+	"digit": true, "graph": true, "lower": true, "print": true,
+	"punct": true, "space": true, "upper": true, "xdigit": true,
+}
+
+// the largest repeat count POSIX requires to work
+const reDupMax = 32767
+
+// the largest repeat count Go's regexp package takes
+const goRepeatMax = 1000
+
+type reParser struct {
+	src      []byte
+	pos      int
+	extended bool
+	groups   int
+	closed   []bool
+}
+
+// parseRegex parses a POSIX basic regular expression, or an extended one
+// when extended is set, with the GNU extensions: \+, \? and \| in basic
+// ones, back references in extended ones and the backslash character
+// classes and anchors in both. It returns the tree and the number of groups
+// in it.
+func parseRegex(src []byte, extended bool) (*reNode, int, error) {
+	p := &reParser{src: src, extended: extended}
+	n, err := p.parseAlternate(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !p.eof() {
+		// the only thing that stops the top level early is a \) or )
+		return nil, 0, UnmatchedCloseParen
+	}
+	return n, p.groups, nil
+}
+
+func (p *reParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+// at reports whether the source continues with s.
+func (p *reParser) at(s string) bool {
+	return bytes.HasPrefix(p.src[p.pos:], []byte(s))
+}
+
+// atOp reports whether the source continues with the operator op, given in
+// its basic form, like \( or \|. In an extended regular expression it is
+// written without the \.
+func (p *reParser) atOp(op string) bool {
+	if p.extended {
+		return p.at(op[1:])
+	}
+	return p.at(op)
+}
+
+// skipOp moves past the operator atOp found.
+func (p *reParser) skipOp(op string) {
+	if p.extended {
+		p.pos += len(op) - 1
+	} else {
+		p.pos += len(op)
+	}
+}
+
+func (p *reParser) nextRune() rune {
+	r, width := utf8.DecodeRune(p.src[p.pos:])
+	p.pos += width
+	return r
+}
+
+func (p *reParser) parseAlternate(depth int) (*reNode, error) {
+	n, err := p.parseBranch(depth)
+	if err != nil {
+		return nil, err
+	}
+	if !p.atOp(`\|`) {
+		return n, nil
+	}
+	alt := &reNode{op: reAlternate, subs: []*reNode{n}}
+	for p.atOp(`\|`) {
+		p.skipOp(`\|`)
+		n, err = p.parseBranch(depth)
+		if err != nil {
+			return nil, err
+		}
+		alt.subs = append(alt.subs, n)
+	}
+	return alt, nil
+}
+
+// atBranchEnd reports whether the branch being parsed ends here, which is
+// where a $ in a basic regular expression is an anchor rather than a
+// literal.
+func (p *reParser) atBranchEnd() bool {
+	return p.eof() || p.atOp(`\|`) || p.atOp(`\)`)
+}
+
+func (p *reParser) parseBranch(depth int) (*reNode, error) {
+	concat := &reNode{op: reConcat}
+	// in a basic regular expression a * is literal at the start of a
+	// branch, even after a ^
+	start := true
+	for !p.atBranchEnd() {
+		atom, err := p.parseAtom(start)
+		if err != nil {
+			return nil, err
+		}
+		start = atom.op == reLineStart && len(concat.subs) == 0
+		// POSIX leaves a** undefined, it is taken as a*
+		for repeated := !start; repeated; {
+			if atom, repeated, err = p.parseRepeat(atom); err != nil {
+				return nil, err
+			}
+		}
+		concat.subs = append(concat.subs, atom)
+	}
+	if p.atOp(`\)`) && depth == 0 {
+		return nil, UnmatchedCloseParen
+	}
+	if len(concat.subs) == 1 {
+		return concat.subs[0], nil
+	}
+	return concat, nil
+}
+
+func (p *reParser) parseAtom(start bool) (*reNode, error) {
+	switch c := p.src[p.pos]; c {
+	case '.':
+		p.pos++
+		return &reNode{op: reAnyChar}, nil
+	case '[':
+		p.pos++
+		return p.parseBracket()
+	case '^':
+		p.pos++
+		if start || p.extended {
+			return &reNode{op: reLineStart}, nil
+		}
+		return &reNode{op: reLiteral, r: '^'}, nil
+	case '$':
+		p.pos++
+		if p.atBranchEnd() || p.extended {
+			return &reNode{op: reLineEnd}, nil
+		}
+		return &reNode{op: reLiteral, r: '$'}, nil
+	case '*':
+		if start && !p.extended {
+			p.pos++
+			return &reNode{op: reLiteral, r: '*'}, nil
+		}
+		return nil, InvalidPrecedingRegex
+	case '(':
+		if p.extended {
+			p.pos++
+			return p.parseGroup()
+		}
+	case '+', '?', '{':
+		if p.extended {
+			return nil, InvalidPrecedingRegex
+		}
+	case '\\':
+		p.pos++
+		return p.parseEscape()
+	}
+	return &reNode{op: reLiteral, r: p.nextRune()}, nil
+}
+
+// parseEscape parses what follows a \ outside a bracket expression.
+func (p *reParser) parseEscape() (*reNode, error) {
+	if p.eof() {
+		return nil, TrailingBackslash
+	}
+	c := p.src[p.pos]
+	switch {
+	case c == '(' && !p.extended:
+		p.pos++
+		return p.parseGroup()
+	case c == '{' && !p.extended:
+		return nil, InvalidPrecedingRegex
+	case c >= '1' && c <= '9':
+		p.pos++
+		group := int(c - '0')
+		if group >= len(p.closed) || !p.closed[group] {
+			return nil, InvalidBackReference
+		}
+		return &reNode{op: reBackRef, group: group}, nil
+	case c == 'n':
+		p.pos++
+		return &reNode{op: reLiteral, r: '\n'}, nil
+	case c == 't':
+		p.pos++
+		return &reNode{op: reLiteral, r: '\t'}, nil
+	case c == 'w' || c == 'W':
+		p.pos++
+		word := &reBracket{negate: c == 'W', classes: []string{"alnum"}, ranges: [][2]rune{{'_', '_'}}}
+		return &reNode{op: reClass, class: word}, nil
+	case c == 's' || c == 'S':
+		p.pos++
+		space := &reBracket{negate: c == 'S', classes: []string{"space"}}
+		return &reNode{op: reClass, class: space}, nil
+	case c == 'b':
+		p.pos++
+		return &reNode{op: reWordBoundary}, nil
+	case c == '<':
+		p.pos++
+		return &reNode{op: reWordStart}, nil
+	case c == '>':
+		p.pos++
+		return &reNode{op: reWordEnd}, nil
+	case c == 'B':
+		p.pos++
+		return &reNode{op: reNotWordBoundary}, nil
+	case c == '`':
+		p.pos++
+		return &reNode{op: reTextStart}, nil
+	case c == '\'':
+		p.pos++
+		return &reNode{op: reTextEnd}, nil
+	}
+	// anything else, like \. or \*, is just the character
+	return &reNode{op: reLiteral, r: p.nextRune()}, nil
+}
+
+// parseGroup parses a group whose opening \( or ( has already been read.
+func (p *reParser) parseGroup() (*reNode, error) {
+	p.groups++
+	group := p.groups
+	n, err := p.parseAlternate(group)
+	if err != nil {
+		return nil, err
+	}
+	if !p.atOp(`\)`) {
+		return nil, UnmatchedParen
+	}
+	p.skipOp(`\)`)
+	for len(p.closed) <= group {
+		p.closed = append(p.closed, false)
+	}
+	p.closed[group] = true
+	return &reNode{op: reGroup, group: group, subs: []*reNode{n}}, nil
+}
+
+// parseRepeat returns atom wrapped in a repeat if a *, \+ or +, \? or ?,
+// or an interval follows it, otherwise it returns atom as it is.
+func (p *reParser) parseRepeat(atom *reNode) (*reNode, bool, error) {
+	repeat := &reNode{op: reRepeat, subs: []*reNode{atom}, max: -1}
+	switch {
+	case p.at("*"):
+		p.pos++
+	case p.atOp(`\+`):
+		p.skipOp(`\+`)
+		repeat.min = 1
+	case p.atOp(`\?`):
+		p.skipOp(`\?`)
+		repeat.max = 1
+	case p.atOp(`\{`):
+		p.skipOp(`\{`)
+		if err := p.parseInterval(repeat); err != nil {
+			return nil, false, err
+		}
+	default:
+		return atom, false, nil
+	}
+	return repeat, true, nil
+}
+
+// parseInterval reads the m, m, or m,n of an interval and its closing \}
+// or }.
+// A missing m is 0.
+func (p *reParser) parseInterval(repeat *reNode) error {
+	var err error
+	if repeat.min, err = p.readCount(0); err != nil {
+		return err
+	}
+	repeat.max = repeat.min
+	if p.at(",") {
+		p.pos++
+		if repeat.max, err = p.readCount(-1); err != nil {
+			return err
+		}
+	}
+	if !p.atOp(`\}`) {
+		if p.eof() || p.extended {
+			return UnmatchedBrace
+		}
+		return InvalidInterval
+	}
+	p.skipOp(`\}`)
+	if repeat.max >= 0 && repeat.max < repeat.min {
+		return InvalidInterval
+	}
+	return nil
+}
+
+// readCount reads the decimal number in an interval, or returns missing if
+// there isn't one.
+func (p *reParser) readCount(missing int) (int, error) {
+	start := p.pos
+	for !p.eof() && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+		p.pos++
+	}
+	if start == p.pos {
+		return missing, nil
+	}
+	n, err := strconv.Atoi(string(p.src[start:p.pos]))
+	if err != nil || n > reDupMax {
+		return 0, InvalidInterval
+	}
+	return n, nil
+}
+
+// parseBracket parses a bracket expression whose opening [ has already been
+// read. A \ is literal in a bracket expression, except that sed turns \n
+// into a newline, \t into a tab and \\ into a single \.
+func (p *reParser) parseBracket() (*reNode, error) {
+	b := new(reBracket)
+	if p.at("^") {
+		p.pos++
+		b.negate = true
+	}
+	first := true
+	for {
+		if p.eof() {
+			return nil, UnmatchedBracket
+		}
+		if p.at("]") && !first {
+			p.pos++
+			break
+		}
+		first = false
+		if p.at("[:") {
+			end := bytes.Index(p.src[p.pos+2:], []byte(":]"))
+			if end < 0 {
+				return nil, UnmatchedBracket
+			}
+			name := string(p.src[p.pos+2 : p.pos+2+end])
+			if !reClassNames[name] {
+				return nil, InvalidCharacterClass
+			}
+			b.classes = append(b.classes, name)
+			p.pos += end + 4
+			continue
+		}
+		lo, err := p.bracketRune()
+		if err != nil {
+			return nil, err
+		}
+		hi := lo
+		if p.at("-") && !p.at("-]") && p.pos+1 < len(p.src) {
+			p.pos++
+			if hi, err = p.bracketRune(); err != nil {
+				return nil, err
+			}
+			if hi < lo {
+				return nil, InvalidRangeEnd
+			}
+		}
+		b.ranges = append(b.ranges, [2]rune{lo, hi})
+	}
+	return &reNode{op: reClass, class: b}, nil
+}
+
+// bracketRune reads one character of a bracket expression, which may be
+// written as a collating symbol [.c.] or an equivalence class [=c=].
+func (p *reParser) bracketRune() (rune, error) {
+	if p.at("[.") || p.at("[=") {
+		end := []byte{p.src[p.pos+1], ']'}
+		p.pos += 2
+		if p.eof() {
+			return 0, UnmatchedBracket
+		}
+		r := p.nextRune()
+		if !p.at(string(end)) {
+			return 0, InvalidCollationCharacter
+		}
+		p.pos += 2
+		return r, nil
+	}
+	if p.at(`\n`) {
+		p.pos += 2
+		return '\n', nil
+	}
+	if p.at(`\t`) {
+		p.pos += 2
+		return '\t', nil
+	}
+	if p.at(`\\`) {
+		p.pos += 2
+		return '\\', nil
+	}
+	return p.nextRune(), nil
+}
+
+// A matcher finds the leftmost longest matches of a regular expression,
+// returning the indexes of the match and its groups like the Submatch
+// methods of Go's regexp package.
+type matcher interface {
+	fmt.Stringer
+	match(b []byte) (bool, error)
+	findSubmatchIndex(b []byte) ([]int, error)
+	// findAllSubmatchIndex stops after n matches, or finds them all if n
+	// is negative
+	findAllSubmatchIndex(b []byte, n int) ([][]int, error)
+	numSubexp() int
+}
+
+// goMatcher matches with Go's regexp package, which is used for every
+// regular expression it can handle.
+type goMatcher struct {
+	re *regexp.Regexp
+}
+
+func (m *goMatcher) String() string {
+	return m.re.String()
+}
+
+func (m *goMatcher) match(b []byte) (bool, error) {
+	return m.re.Match(b), nil
+}
+
+func (m *goMatcher) findSubmatchIndex(b []byte) ([]int, error) {
+	return m.re.FindSubmatchIndex(b), nil
+}
+
+func (m *goMatcher) findAllSubmatchIndex(b []byte, n int) ([][]int, error) {
+	return m.re.FindAllSubmatchIndex(b, n), nil
+}
+
+func (m *goMatcher) numSubexp() int {
+	return m.re.NumSubexp()
+}
+
+// flags for compileRegex
+const (
+	reIgnoreCase = 1 << iota
+	reMultiline
+)
+
+// compileRegex compiles a sed regular expression, reading it as an
+// extended regular expression if -E or -r was given. Like sed's, it finds
+// the leftmost longest match and . matches a newline in the pattern space.
+// With reMultiline ^ and $ also match next to a newline, and neither . nor
+// a negated bracket expression match one. Go's regexp package can't match
+// back references, the start or end of a word next to something that might
+// not be a word character, or repeat counts over 1000, so a regular
+// expression with one of those is matched by backtracking instead.
+func compileRegex(src []byte, flags int) (matcher, error) {
+	n, groups, err := parseRegex(src, *extended_regexp)
+	if err != nil {
+		return nil, err
+	}
+	if flags&reMultiline != 0 {
+		n.excludeNewline()
+	}
+	n.wordAnchorsToBoundaries(flags&reIgnoreCase != 0)
+	if n.needsBacktracking() {
+		return newBacktracker(string(src), n, groups, flags), nil
+	}
+	buf := bytes.NewBufferString("(?s")
+	if flags&reMultiline != 0 {
+		buf.WriteByte('m')
+	}
+	if flags&reIgnoreCase != 0 {
+		buf.WriteByte('i')
+	}
+	buf.WriteByte(')')
+	n.writeGo(buf)
+	re, err := regexp.Compile(buf.String())
+	if err != nil {
+		return nil, err
+	}
+	re.Longest()
+	return &goMatcher{re}, nil
+}
+
+// excludeNewline stops . and negated bracket expressions in n matching a
+// newline.
+func (n *reNode) excludeNewline() {
+	switch {
+	case n.op == reAnyChar:
+		n.op = reClass
+		n.class = &reBracket{negate: true}
+		fallthrough
+	case n.op == reClass && n.class.negate:
+		n.class.ranges = append(n.class.ranges, [2]rune{'\n', '\n'})
+	}
+	for _, sub := range n.subs {
+		sub.excludeNewline()
+	}
+}
+
+// needsBacktracking reports whether n has anything Go's regexp package
+// can't match.
+func (n *reNode) needsBacktracking() bool {
+	if n.op == reBackRef || n.op == reWordStart || n.op == reWordEnd {
+		return true
+	}
+	if n.op == reRepeat && (n.min > goRepeatMax || n.max > goRepeatMax) {
+		return true
+	}
+	for _, sub := range n.subs {
+		if sub.needsBacktracking() {
+			return true
+		}
+	}
+	return false
+}
+
+// wordAnchorsToBoundaries turns \< before something that always starts with
+// a word character, and \> after something that always ends with one,
+// into \b, which means the same there and Go's regexp package can match.
+func (n *reNode) wordAnchorsToBoundaries(ignoreCase bool) {
+	if n.op == reConcat {
+		for i, sub := range n.subs {
+			switch {
+			case sub.op == reWordStart && i+1 < len(n.subs) && n.subs[i+1].wordAtEdge(true, ignoreCase):
+				sub.op = reWordBoundary
+			case sub.op == reWordEnd && i > 0 && n.subs[i-1].wordAtEdge(false, ignoreCase):
+				sub.op = reWordBoundary
+			}
+		}
+	}
+	for _, sub := range n.subs {
+		sub.wordAnchorsToBoundaries(ignoreCase)
+	}
+}
+
+// wordAtEdge reports whether everything n matches starts, or if start is
+// false ends, with a word character.
+func (n *reNode) wordAtEdge(start, ignoreCase bool) bool {
+	switch n.op {
+	case reLiteral:
+		return isWordRune(n.r, ignoreCase)
+	case reClass:
+		return n.class.isWord(ignoreCase)
+	case reGroup:
+		return n.subs[0].wordAtEdge(start, ignoreCase)
+	case reRepeat:
+		return n.min > 0 && n.subs[0].wordAtEdge(start, ignoreCase)
+	case reConcat:
+		if len(n.subs) == 0 {
+			return false
+		}
+		if start {
+			return n.subs[0].wordAtEdge(start, ignoreCase)
+		}
+		return n.subs[len(n.subs)-1].wordAtEdge(start, ignoreCase)
+	case reAlternate:
+		for _, sub := range n.subs {
+			if !sub.wordAtEdge(start, ignoreCase) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// isWord reports whether the bracket expression only matches word
+// characters.
+func (b *reBracket) isWord(ignoreCase bool) bool {
+	if b.negate {
+		return false
+	}
+	for _, name := range b.classes {
+		switch name {
+		case "alnum", "alpha", "digit", "lower", "upper":
+		default:
+			return false
+		}
+	}
+	for _, r := range b.ranges {
+		if r[1] > unicode.MaxASCII {
+			return false
+		}
+		for c := r[0]; c <= r[1]; c++ {
+			if !isWordRune(c, ignoreCase) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isWordRune reports whether r, and when ignoring case everything it folds
+// to, is an ASCII word character, as \b sees them.
+func isWordRune(r rune, ignoreCase bool) bool {
+	isWord := func(r rune) bool {
+		return r == '_' || '0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z'
+	}
+	if !isWord(r) {
+		return false
+	}
+	for f := unicode.SimpleFold(r); ignoreCase && f != r; f = unicode.SimpleFold(f) {
+		if !isWord(f) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeGo writes n in the syntax of Go's regexp package. n must not need
+// backtracking.
+func (n *reNode) writeGo(buf *bytes.Buffer) {
+	switch n.op {
+	case reLiteral:
+		buf.WriteString(regexp.QuoteMeta(string(n.r)))
+	case reAnyChar:
+		buf.WriteByte('.')
+	case reClass:
+		n.class.writeGo(buf)
+	case reLineStart:
+		buf.WriteByte('^')
+	case reLineEnd:
+		buf.WriteByte('$')
+	case reTextStart:
+		buf.WriteString(`\A`)
+	case reTextEnd:
+		buf.WriteString(`\z`)
+	case reWordBoundary:
+		buf.WriteString(`\b`)
+	case reNotWordBoundary:
+		buf.WriteString(`\B`)
+	case reGroup:
+		buf.WriteByte('(')
+		n.subs[0].writeGo(buf)
+		buf.WriteByte(')')
+	case reConcat, reAlternate:
+		for i, sub := range n.subs {
+			if i > 0 && n.op == reAlternate {
+				buf.WriteByte('|')
+			}
+			sub.writeGoNested(buf, sub.op == reAlternate)
+		}
+	case reRepeat:
+		sub := n.subs[0]
+		sub.writeGoNested(buf, sub.op != reLiteral && sub.op != reAnyChar && sub.op != reClass && sub.op != reGroup)
+		switch {
+		case n.min == 0 && n.max < 0:
+			buf.WriteByte('*')
+		case n.min == 1 && n.max < 0:
+			buf.WriteByte('+')
+		case n.min == 0 && n.max == 1:
+			buf.WriteByte('?')
+		case n.max < 0:
+			buf.WriteString("{" + strconv.Itoa(n.min) + ",}")
+		case n.min == n.max:
+			buf.WriteString("{" + strconv.Itoa(n.min) + "}")
+		default:
+			buf.WriteString("{" + strconv.Itoa(n.min) + "," + strconv.Itoa(n.max) + "}")
+		}
+	}
+}
+
+// writeGoNested writes n, in a non-capturing group when wrap is set.
+func (n *reNode) writeGoNested(buf *bytes.Buffer, wrap bool) {
+	if !wrap {
+		n.writeGo(buf)
+		return
+	}
+	buf.WriteString("(?:")
+	n.writeGo(buf)
+	buf.WriteByte(')')
+}
+
+func (b *reBracket) writeGo(buf *bytes.Buffer) {
+	buf.WriteByte('[')
+	if b.negate {
+		buf.WriteByte('^')
+	}
+	for _, r := range b.ranges {
+		writeGoClassRune(buf, r[0])
+		if r[1] != r[0] {
+			buf.WriteByte('-')
+			writeGoClassRune(buf, r[1])
+		}
+	}
+	for _, name := range b.classes {
+		buf.WriteString("[:" + name + ":]")
+	}
+	buf.WriteByte(']')
+}
+
+func writeGoClassRune(buf *bytes.Buffer, r rune) {
+	switch r {
+	case '\\', ']', '[', '^', '-':
+		buf.WriteByte('\\')
+	}
+	buf.WriteRune(r)
+}
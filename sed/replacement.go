@@ -0,0 +1,162 @@
+//
+//  replacement.go
+//  sed
+//
+// Copyright (c) 2009 Geoffrey Clements
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+
+package sed
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A replacementPart is either literal text or, when group is 0 or more, the
+// text matched by that group of the regular expression. Group 0 is the
+// whole match. A part with caseChange set changes the case of what follows
+// it instead, caseChange is the letter of the escape: U, L, E, u or l.
+type replacementPart struct {
+	literal    []byte
+	group      int
+	caseChange byte
+}
+
+// replacement is the right hand side of an s command split into parts so
+// it doesn't have to be parsed again for every match.
+type replacement []replacementPart
+
+// parseReplacement splits the replacement text of an s command into parts.
+// & and \0 are the whole match, \1 to \9 are groups, \n is a newline and \t
+// a tab. \U and \L turn what follows into upper or lower case until a \E,
+// \u and \l just the next character. A \ before any other character,
+// including & and \, makes it literal. groups is the number of groups in
+// the regular expression, a reference to a group past that is an error.
+func parseReplacement(text []byte, groups int) (replacement, error) {
+	var r replacement
+	var literal []byte
+	addPart := func(part replacementPart) {
+		if len(literal) > 0 {
+			r = append(r, replacementPart{literal: literal, group: -1})
+			literal = nil
+		}
+		r = append(r, part)
+	}
+	for i := 0; i < len(text); i++ {
+		b := text[i]
+		switch {
+		case b == '&':
+			addPart(replacementPart{group: 0})
+		case b == '\\' && i+1 < len(text):
+			i++
+			b = text[i]
+			switch {
+			case b >= '0' && b <= '9':
+				group := int(b - '0')
+				if group > groups {
+					return nil, fmt.Errorf("%w: \\%d", InvalidReference, group)
+				}
+				addPart(replacementPart{group: group})
+			case b == 'n':
+				literal = append(literal, '\n')
+			case b == 't':
+				literal = append(literal, '\t')
+			case b == 'U' || b == 'L' || b == 'E' || b == 'u' || b == 'l':
+				addPart(replacementPart{group: -1, caseChange: b})
+			default:
+				literal = append(literal, b)
+			}
+		default:
+			literal = append(literal, b)
+		}
+	}
+	if len(literal) > 0 {
+		r = append(r, replacementPart{literal: literal, group: -1})
+	}
+	return r, nil
+}
+
+// expand appends the replacement for one match to dst. match holds the
+// submatch indexes into src, as returned by FindSubmatchIndex. A group that
+// didn't take part in the match adds nothing.
+func (r replacement) expand(dst, src []byte, match []int) []byte {
+	var cc caseConverter
+	for _, part := range r {
+		switch {
+		case part.caseChange != 0:
+			cc.change(part.caseChange)
+		case part.group < 0:
+			dst = cc.append(dst, part.literal)
+		case match[2*part.group] >= 0:
+			dst = cc.append(dst, src[match[2*part.group]:match[2*part.group+1]])
+		}
+	}
+	return dst
+}
+
+// caseConverter tracks the case changes in force while expanding a
+// replacement. mode is U or L until an E, next is u or l for the next
+// character only.
+type caseConverter struct {
+	mode, next byte
+}
+
+func (cc *caseConverter) change(c byte) {
+	switch c {
+	case 'U', 'L':
+		// a \U or \L after a \u or \l cancels it
+		cc.mode, cc.next = c, 0
+	case 'E':
+		cc.mode, cc.next = 0, 0
+	default:
+		cc.next = c
+	}
+}
+
+// append appends text to dst converting its case a rune at a time. Bytes
+// that aren't UTF-8 are copied as they are.
+func (cc *caseConverter) append(dst, text []byte) []byte {
+	if cc.mode == 0 && cc.next == 0 {
+		return append(dst, text...)
+	}
+	for len(text) > 0 {
+		r, width := utf8.DecodeRune(text)
+		if r == utf8.RuneError && width <= 1 {
+			dst = append(dst, text[0])
+			text = text[1:]
+			continue
+		}
+		text = text[width:]
+		c := cc.mode
+		if cc.next != 0 {
+			c, cc.next = cc.next, 0
+		}
+		switch c {
+		case 'U', 'u':
+			r = unicode.ToUpper(r)
+		case 'L', 'l':
+			r = unicode.ToLower(r)
+		}
+		dst = utf8.AppendRune(dst, r)
+	}
+	return dst
+}
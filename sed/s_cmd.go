@@ -27,108 +27,178 @@ package sed
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"regexp"
+	"os/exec"
 	"strconv"
 )
 
-const (
-	global_replace = -1
-)
-
 type s_cmd struct {
 	addr         *address
 	regex        string
 	replace      []byte
+	replacement  replacement
 	nthOccurance int
-	re           *regexp.Regexp
+	global       bool
+	print        bool
+	printFirst   bool
+	eval         bool
+	wfilename    []byte
+	wfile        *wfile
+	re           matcher
 }
 
-func (c *s_cmd) match(line []byte, lineNumber int) bool {
-	return c.addr.match(line, lineNumber)
+func (c *s_cmd) match(s *Sed) bool {
+	return c.addr.match(s)
 }
 
 func (c *s_cmd) String() string {
 	if c != nil {
 		if c.addr != nil {
-			return fmt.Sprintf("{s command addr:%s regex:%v replace:%s nth occurance:%d}", c.addr, c.regex, c.replace, c.nthOccurance)
+			return fmt.Sprintf("{s command addr:%s regex:%v replace:%s nth occurance:%d global:%v}", c.addr, c.regex, c.replace, c.nthOccurance, c.global)
 		}
-		return fmt.Sprintf("{s command regex:%v replace:%s nth occurance:%d}", c.regex, c.replace, c.nthOccurance)
+		return fmt.Sprintf("{s command regex:%v replace:%s nth occurance:%d global:%v}", c.regex, c.replace, c.nthOccurance, c.global)
 	}
 	return "{s command}"
 }
 
-func NewSCmd(pieces [][]byte, addr *address) (c *s_cmd, err error) {
-	if len(pieces) != 4 {
-		return nil, WrongNumberOfCommandParameters
-	}
-
+// NewSCmd makes an s command. The flags can come in any order: a number to
+// replace that match rather than the first, g to replace every match, or
+// with a number every match from that one on, p to print the pattern space
+// after a substitution, i or I to ignore case, m or M for ^ and $ to match
+// at newlines, e to run the pattern space as a shell command and replace it
+// with the output, and w to write the pattern space to the file named by
+// the rest of the flags. The caller opens that file, named by wfilename.
+func NewSCmd(regex, replace, flags []byte, addr *address) (c *s_cmd, err error) {
 	err = nil
 	c = new(s_cmd)
 	c.addr = addr
 
-	c.regex = string(pieces[1])
+	c.regex = string(regex)
 	if len(c.regex) == 0 {
 		return nil, RegularExpressionExpected
 	}
-	c.re, err = regexp.Compile(string(c.regex))
-	if err != nil {
-		return nil, err
-	}
 
-	c.replace = pieces[2]
-
-	flag := string(pieces[3])
-	if flag != "g" {
-		c.nthOccurance = 1
-		if len(flag) > 0 {
-			c.nthOccurance, err = strconv.Atoi(flag)
+	reFlags := 0
+	for i := 0; i < len(flags); i++ {
+		// flag errors say which flag is wrong
+		flagError := func(err error) error {
+			return &argError{err: err, offset: i}
+		}
+		f := flags[i]
+		switch f {
+		case ' ', '\t':
+		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			if c.nthOccurance != 0 {
+				return nil, flagError(fmt.Errorf("%w: number", RepeatedSCommandFlag))
+			}
+			end := i
+			for end < len(flags) && flags[end] >= '0' && flags[end] <= '9' {
+				end++
+			}
+			c.nthOccurance, err = strconv.Atoi(string(flags[i:end]))
 			if err != nil {
-				return nil, InvalidSCommandFlag
+				return nil, flagError(InvalidSCommandFlag)
+			}
+			if c.nthOccurance == 0 {
+				return nil, flagError(ZeroSCommandOccurrence)
+			}
+			i = end - 1
+		case 'g':
+			if c.global {
+				return nil, flagError(fmt.Errorf("%w: g", RepeatedSCommandFlag))
 			}
+			c.global = true
+		case 'p':
+			if c.print {
+				return nil, flagError(fmt.Errorf("%w: p", RepeatedSCommandFlag))
+			}
+			c.print = true
+		case 'e':
+			if c.eval {
+				return nil, flagError(fmt.Errorf("%w: e", RepeatedSCommandFlag))
+			}
+			// a p before the e prints the command rather than its output
+			c.eval = true
+			c.printFirst = c.print
+		case 'i', 'I':
+			reFlags |= reIgnoreCase
+		case 'm', 'M':
+			reFlags |= reMultiline
+		case 'w':
+			c.wfilename = bytes.TrimLeft(flags[i+1:], " \t")
+			if len(c.wfilename) == 0 {
+				return nil, flagError(MissingFilename)
+			}
+			i = len(flags)
+		default:
+			return nil, flagError(InvalidSCommandFlag)
 		}
-	} else {
-		c.nthOccurance = global_replace
+	}
+	if c.nthOccurance == 0 {
+		c.nthOccurance = 1
+	}
+
+	c.re, err = compileRegex(regex, reFlags)
+	if err != nil {
+		return nil, err
 	}
 
-	return c, err
+	c.replace = replace
+	c.replacement, err = parseReplacement(replace, c.re.numSubexp())
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
 func (c *s_cmd) processLine(s *Sed) (stop bool, err error) {
 	stop, err = false, nil
+	// the matches don't overlap, and an empty match right after another
+	// match doesn't count, as in sed. Without g only the matches up to the
+	// one being replaced are needed.
+	n := -1
+	if !c.global {
+		n = c.nthOccurance
+	}
+	matches, err := c.re.findAllSubmatchIndex(s.patternSpace, n)
+	if err != nil {
+		return false, err
+	}
+	if len(matches) < c.nthOccurance {
+		return false, nil
+	}
+	matches = matches[c.nthOccurance-1:]
+	if !c.global {
+		matches = matches[:1]
+	}
+	line := s.patternSpace
+	s.patternSpace = make([]byte, 0, len(line))
+	last := 0
+	for _, m := range matches {
+		s.patternSpace = append(s.patternSpace, line[last:m[0]]...)
+		s.patternSpace = c.replacement.expand(s.patternSpace, line, m)
+		last = m[1]
+	}
+	s.patternSpace = append(s.patternSpace, line[last:]...)
+	s.substituted = true
 
-	switch c.nthOccurance {
-	case global_replace:
-		s.patternSpace = c.re.ReplaceAll(s.patternSpace, c.replace)
-	default:
-		// a numeric flag command
-		count := 0
-		line := s.patternSpace
-		s.patternSpace = make([]byte, 0)
-		for {
-			matches := c.re.FindIndex(line)
-			if len(matches) > 0 {
-				count++
-				if count == c.nthOccurance {
-					buf := bytes.NewBuffer(s.patternSpace)
-					buf.Write(line[0:matches[0]])
-					buf.Write(c.replace)
-					buf.Write(line[matches[1]:])
-					s.patternSpace = buf.Bytes()
-					break
-				} else {
-					buf := bytes.NewBuffer(s.patternSpace)
-					buf.Write(line[0 : matches[0]+1])
-					s.patternSpace = buf.Bytes()
-				}
-				line = line[matches[0]+1:]
-			} else {
-				buf := bytes.NewBuffer(s.patternSpace)
-				buf.Write(line)
-				s.patternSpace = buf.Bytes()
-				break
-			}
+	if c.print && c.printFirst {
+		s.printPatternSpace()
+	}
+	if c.eval {
+		out, err := exec.Command("sh", "-c", string(s.patternSpace)).Output()
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			return false, err
 		}
+		s.patternSpace = bytes.TrimSuffix(out, newLine)
+	}
+	if c.print && !c.printFirst {
+		s.printPatternSpace()
+	}
+	if c.wfile != nil {
+		c.wfile.writeLine(s, s.patternSpace, true)
 	}
 	return stop, err
 }
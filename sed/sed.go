@@ -28,14 +28,11 @@ package sed
 import (
 	"bufio"
 	"bytes"
-	"container/list"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
-	"unicode"
-	"unicode/utf8"
 )
 
 const (
@@ -48,6 +45,7 @@ var versionString string
 
 func init() {
 	versionString = fmt.Sprintf("%d.%d.%d", versionMajor, versionMinor, versionPoint)
+	flag.BoolVar(extended_regexp, "r", false, "Same as -E.")
 }
 
 var show_version = flag.Bool("version", false, "Show version information.")
@@ -56,33 +54,48 @@ var quiet = flag.Bool("n", false, "Don't print the pattern space at the end of e
 var script = flag.String("e", "", "The script used to process the input file.")
 var script_file = flag.String("f", "", "Specify a file to read as the script. Ignored if -e present")
 var edit_inplace = flag.Bool("i", false, "This option specifies that files are to be edited in-place. Otherwise output is printed to stdout.")
-var line_wrap = flag.Uint("l", 0, "Specify the default line-wrap length for the l command. A length of 0 (zero) means to never wrap long lines. If not specified, it is taken to be 70.")
+var line_wrap = flag.Uint("l", 70, "Specify the default line-wrap length for the l command. A length of 0 (zero) means to never wrap long lines. If not specified, it is taken to be 70.")
 var unbuffered = flag.Bool("u", false, "Buffer both input and output as minimally as practical. (ignored)")
 var treat_files_as_seperate = flag.Bool("s", false, "Treat files as searate entites. Line numbers reset to 1 for each file")
+var posix = flag.Bool("posix", false, "Follow POSIX where GNU sed differs: N on the last line quits without printing the pattern space.")
+var extended_regexp = flag.Bool("E", false, "Use extended regular expressions rather than basic ones.")
+var backtrack_limit = flag.Int("backtrack-limit", 1000000, "The most steps matching a regular expression with a back reference may take.")
 
 var usageShown bool = false
 
 var newLine = []byte{'\n'}
 
+// appended is output queued by an a, r or R command for the end of the
+// cycle. It is text, written as it is, or the contents of a file, read when
+// they are written out.
+type appended struct {
+	text     []byte
+	filename string
+}
+
 type Sed struct {
 	inputFile               *os.File
-	input                   *bufio.Reader
+	input                   *lineReader
 	lineNumber              int
 	currentLine             string
-	beforeCommands          *list.List
-	commands                *list.List
-	afterCommands           *list.List
-	outputFile              *os.File
+	program                 []Cmd
+	pc                      int
+	appendQueue             []appended
+	rfiles                  map[string]*bufio.Reader
+	wfiles                  map[string]*wfile
+	output                  io.Writer
+	outputMissingNewLine    bool
 	patternSpace, holdSpace []byte
-	scriptLines             [][]byte
-	scriptLineNumber        int
+	substituted             bool
+	restart                 bool
+	regexErr                error
+	quit                    *quitStatus
 }
 
 func (s *Sed) Init() {
-	s.beforeCommands = new(list.List)
-	s.commands = new(list.List)
-	s.afterCommands = new(list.List)
-	s.outputFile = os.Stdout
+	s.output = os.Stdout
+	s.rfiles = make(map[string]*bufio.Reader)
+	s.wfiles = make(map[string]*wfile)
 	s.patternSpace = make([]byte, 0)
 	s.holdSpace = make([]byte, 0)
 }
@@ -104,145 +117,278 @@ func usage() {
 
 var inputFilename string
 
-func (s *Sed) getNextScriptLine() ([]byte, error) {
-	if s.scriptLineNumber < len(s.scriptLines) {
-		val := s.scriptLines[s.scriptLineNumber]
-		s.scriptLineNumber++
-		return val, nil
+// parseScript compiles the script into s.program. Commands are stored in
+// script order and branches are resolved to the index of their label so
+// process can run the program with a program counter.
+func (s *Sed) parseScript(scriptBuffer []byte) (err error) {
+	lx := newScriptLexer(scriptBuffer)
+	s.program = s.program[0:0]
+	labels := make(map[string]int)
+	var blocks []*block_cmd
+	if bytes.Equal(scriptBuffer, []byte("#n")) || bytes.HasPrefix(scriptBuffer, []byte("#n\n")) {
+		// spcial case where the first 2 characters of the file are #n which is
+		// equivalent to passing -n on the command line
+		*quiet = true
 	}
-	return nil, io.EOF
-}
-
-func trimSpaceFromBeginning(s []byte) []byte {
-	start, end := 0, len(s)
-	for start < end {
-		r, wid := utf8.DecodeRune(s[start:end])
-		if !unicode.IsSpace(r) {
+	for {
+		// a script may be a single command or it may be several
+		lx.skipSpace()
+		if lx.eof() {
 			break
 		}
-		start += wid
+		switch lx.peek() {
+		case ';':
+			lx.next()
+		case '#':
+			lx.skipComment()
+		case '}':
+			if len(blocks) == 0 {
+				return lx.error(UnexpectedBlockEnd)
+			}
+			blocks[len(blocks)-1].end = len(s.program)
+			blocks = blocks[0 : len(blocks)-1]
+			lx.next()
+			if err = lx.endCommand(WrongNumberOfCommandParameters); err != nil {
+				return err
+			}
+		default:
+			pos := lx.pos
+			c, err := parseCmd(s, lx)
+			if err != nil {
+				return err
+			}
+			switch cmd := c.(type) {
+			case *colon_cmd:
+				if _, ok := labels[cmd.label]; ok {
+					return lx.errorAt(DuplicateLabel, pos)
+				}
+				labels[cmd.label] = len(s.program)
+			case *b_cmd:
+				cmd.scriptPos = pos
+			case *t_cmd:
+				cmd.scriptPos = pos
+			case *block_cmd:
+				cmd.scriptPos = pos
+				blocks = append(blocks, cmd)
+			}
+			s.program = append(s.program, c)
+		}
+	}
+	if len(blocks) > 0 {
+		return lx.errorAt(UnterminatedBlock, blocks[len(blocks)-1].scriptPos)
 	}
-	return s[start:end]
+	return s.resolveLabels(lx, labels)
 }
 
-func (s *Sed) parseScript(scriptBuffer []byte) (err error) {
-	// a script may be a single command or it may be several
-	s.scriptLines = bytes.Split(scriptBuffer, newLine)
-	s.scriptLineNumber = 0
-	var line []byte
-	var serr error
-	for line, serr = s.getNextScriptLine(); serr == nil; line, serr = s.getNextScriptLine() {
-		// line = bytes.TrimSpace(line);
-		line = trimSpaceFromBeginning(line)
-		if len(line) == 0 {
-			// zero length line
-			continue
+// resolveLabels points every b, t and T command at its label. A branch
+// without a label jumps to the end of the program.
+func (s *Sed) resolveLabels(lx *scriptLexer, labels map[string]int) error {
+	resolve := func(label string, scriptPos int) (int, error) {
+		if len(label) == 0 {
+			return len(s.program), nil
 		}
-		if line[0] == '#' {
-			if s.scriptLineNumber == 1 && len(line) > 1 && line[1] == 'n' {
-				// spcial case where the first 2 characters of the file are #n which is
-				// equivalent to passing -n on the command line
-				*quiet = true
-			}
-			continue
+		pc, ok := labels[label]
+		if !ok {
+			return 0, lx.errorAt(UndefinedLabel, scriptPos)
 		}
-		c, err := NewCmd(s, line)
-		if err != nil {
-			fmt.Printf("Script error: %s -> %d: %s\n", err.Error(), s.scriptLineNumber, line)
-			os.Exit(-1)
+		return pc, nil
+	}
+	var err error
+	for _, c := range s.program {
+		switch cmd := c.(type) {
+		case *b_cmd:
+			cmd.target, err = resolve(cmd.label, cmd.scriptPos)
+		case *t_cmd:
+			cmd.target, err = resolve(cmd.label, cmd.scriptPos)
 		}
-		if _, ok := c.(*i_cmd); ok {
-			s.beforeCommands.PushBack(c)
-		} else if _, ok := c.(*a_cmd); ok {
-			s.afterCommands.PushBack(c)
-		} else {
-			s.commands.PushBack(c)
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func (s *Sed) printLine(line []byte) {
-	l := len(line)
-	if *line_wrap <= 0 || l < int(*line_wrap) {
-		fmt.Fprintf(s.outputFile, "%s\n", line)
+func (s *Sed) printPatternSpace() {
+	s.writeMissingNewLine()
+	s.output.Write(s.patternSpace)
+	s.endPatternSpace()
+}
+
+// endPatternSpace writes the newline after the pattern space. Like GNU sed,
+// if the last line of input had no newline, the pattern space printed for it
+// doesn't get one either, unless something else is written after it.
+func (s *Sed) endPatternSpace() {
+	if s.input != nil && s.input.missingNewLine {
+		s.outputMissingNewLine = true
 	} else {
-		// print the line in segments
-		for i := 0; i < l; i += int(*line_wrap) {
-			endOfLine := i + int(*line_wrap)
-			if endOfLine > l {
-				endOfLine = l
-			}
-			fmt.Fprintf(s.outputFile, "%s\n", line[i:endOfLine])
+		s.output.Write(newLine)
+	}
+}
+
+// writeMissingNewLine writes the newline left off the pattern space of a
+// last line without one, because more output is following it.
+func (s *Sed) writeMissingNewLine() {
+	if s.outputMissingNewLine {
+		s.output.Write(newLine)
+		s.outputMissingNewLine = false
+	}
+}
+
+// printText writes text, such as that of an a, i or c command, on a line of
+// its own.
+func (s *Sed) printText(text []byte) {
+	s.writeMissingNewLine()
+	s.output.Write(text)
+	s.output.Write(newLine)
+}
+
+// readLine reads the next line of input into the pattern space, or appends
+// it to the pattern space after a newline. Text queued by a commands is
+// written out first. It returns false when there is no more input.
+func (s *Sed) readLine(appendLine bool) bool {
+	line, ok := s.input.readLine()
+	if !ok {
+		return false
+	}
+	s.flushAppendQueue()
+	if appendLine {
+		buf := bytes.NewBuffer(s.patternSpace)
+		buf.WriteRune('\n')
+		buf.Write(line)
+		s.patternSpace = buf.Bytes()
+	} else {
+		s.patternSpace = line
+	}
+	s.currentLine = string(line)
+	// track line number starting with line 1
+	s.lineNumber++
+	s.substituted = false
+	return true
+}
+
+// isLastLine reports whether the current line is the last line of the input.
+func (s *Sed) isLastLine() bool {
+	return s.input.isLast()
+}
+
+// runProgram executes the program against the pattern space, starting at the
+// first command. Commands may move the program counter to branch.
+func (s *Sed) runProgram() (stop bool, err error) {
+	for s.pc = 0; s.pc < len(s.program); {
+		c := s.program[s.pc]
+		s.pc++
+		// ask the command if it should run, based on its address
+		if c.match(s) {
+			stop, err = c.processLine(s)
+		}
+		// matching a regular expression address can fail too
+		if err == nil {
+			err = s.regexErr
+		}
+		if err != nil || stop {
+			return stop, err
 		}
 	}
+	return false, nil
 }
 
-func (s *Sed) printPatternSpace() {
-	lines := bytes.Split(s.patternSpace, newLine)
-	for _, line := range lines {
-		s.printLine(line)
+// openWFile returns the file for a w command, creating it the first time
+// it is named. /dev/stdout and /dev/stderr are the standard output and
+// error.
+func (s *Sed) openWFile(name []byte) (*wfile, error) {
+	if len(name) == 0 {
+		return nil, MissingFilename
+	}
+	if s == nil {
+		// a command parsed on its own with NewCmd has nowhere to keep it
+		return nil, NoSedForFile
+	}
+	if w, ok := s.wfiles[string(name)]; ok {
+		return w, nil
+	}
+	w := &wfile{name: string(name)}
+	switch w.name {
+	case "/dev/stdout":
+		w.f = os.Stdout
+	case "/dev/stderr":
+		w.f = os.Stderr
+	default:
+		f, err := os.Create(w.name)
+		if err != nil {
+			return nil, err
+		}
+		w.f = f
 	}
+	s.wfiles[w.name] = w
+	return w, nil
 }
 
-func (s *Sed) process() {
+// closeWFiles closes the files written by w commands.
+func (s *Sed) closeWFiles() {
+	for _, w := range s.wfiles {
+		if w.f != os.Stdout && w.f != os.Stderr {
+			w.f.Close()
+		}
+	}
+}
+
+// flushAppendQueue writes the output queued by a, r and R commands during
+// this cycle.
+func (s *Sed) flushAppendQueue() {
+	for _, a := range s.appendQueue {
+		s.writeMissingNewLine()
+		if a.filename == "" {
+			s.output.Write(a.text)
+		} else if text, err := os.ReadFile(a.filename); err == nil {
+			s.output.Write(text)
+		}
+	}
+	s.appendQueue = s.appendQueue[0:0]
+}
+
+// process runs the program over the input. It returns the quit status if a
+// q or Q command stopped it before the end of the input.
+func (s *Sed) process() *quitStatus {
 	if *treat_files_as_seperate || *edit_inplace {
 		s.lineNumber = 0
 	}
-	var err error
-	s.patternSpace, err = s.input.ReadSlice('\n')
-	for err != io.EOF {
-		lineLength := len(s.patternSpace)
-		if lineLength > 0 {
-			s.patternSpace = s.patternSpace[0 : lineLength-1]
+	// a cycle restarted by D runs again on the pattern space it left
+	for s.restart || s.readLine(false) {
+		s.restart = false
+		stop, perr := s.runProgram()
+		if perr != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", perr.Error())
+			fmt.Fprintf(os.Stderr, "Line: %d:%s\n", s.lineNumber, s.currentLine)
+			fmt.Fprintf(os.Stderr, "Command: %s\n", s.program[s.pc-1].String())
+			os.Exit(-1)
 		}
-		s.currentLine = string(s.patternSpace)
-		// track line number starting with line 1
-		s.lineNumber++
-		stop := false
-		// process i commands
-		for c := s.beforeCommands.Front(); c != nil; c = c.Next() {
-			// ask the sed if we should process this command, based on address
-			if cmd, ok := c.Value.(*i_cmd); ok {
-				if c.Value.(Address).match(s.patternSpace, s.lineNumber) {
-					s.outputFile.Write(cmd.text)
-				}
-			}
-		}
-		for c := s.commands.Front(); c != nil; c = c.Next() {
-			// ask the sed if we should process this command, based on address
-			if c.Value.(Address).match(s.patternSpace, s.lineNumber) {
-				var err error
-				stop, err = c.Value.(Cmd).processLine(s)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
-					fmt.Fprintf(os.Stderr, "Line: %d:%s\n", s.lineNumber, s.currentLine)
-					fmt.Fprintf(os.Stderr, "Command: %s\n", c.Value.(Cmd).String())
-					os.Exit(-1)
-				}
-				if stop {
-					break
+		if s.quit != nil {
+			if s.quit.print {
+				if !*quiet {
+					s.printPatternSpace()
 				}
+				s.flushAppendQueue()
 			}
+			return s.quit
 		}
 		if !*quiet && !stop {
 			s.printPatternSpace()
 		}
-		// process a commands
-		for c := s.afterCommands.Front(); c != nil; c = c.Next() {
-			// ask the sed if we should process this command, based on address
-			if cmd, ok := c.Value.(*a_cmd); ok {
-				if c.Value.(Address).match(s.patternSpace, s.lineNumber) {
-					fmt.Fprintf(s.outputFile, "%s\n", cmd.text)
-				}
-			}
+		// appended text waits for the next line to be read when D restarts
+		if !s.restart {
+			s.flushAppendQueue()
 		}
-		s.patternSpace, err = s.input.ReadSlice('\n')
 	}
+	if s.input.err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading input: %s\n", s.input.err.Error())
+		os.Exit(-1)
+	}
+	return nil
 }
 
 func Main() {
 	var err error
+	var quit *quitStatus
 	s := new(Sed)
 	s.Init()
 	flag.Parse()
@@ -271,25 +417,11 @@ func Main() {
 		} else if flag.NArg() > 1 {
 			scriptBuffer = []byte(flag.Arg(0))
 
-			// change semicoluns to newlines for scripts on command line
-			idx := bytes.IndexByte(scriptBuffer, ';')
-			for idx >= 0 {
-				scriptBuffer[idx] = '\n'
-				s := scriptBuffer[idx+1:]
-				idx = bytes.IndexByte(s, ';')
-			}
 			// first parameter was the script so move to second parameter
 			currentFileParameter++
 		}
 	} else {
 		scriptBuffer = []byte(*script)
-		// change semicoluns to newlines for scripts on command line
-		idx := bytes.IndexByte(scriptBuffer, ';')
-		for idx >= 0 {
-			scriptBuffer[idx] = '\n'
-			s := scriptBuffer[idx+1:]
-			idx = bytes.IndexByte(s, ';')
-		}
 	}
 
 	// if script still isn't set we are screwed, exit.
@@ -300,16 +432,36 @@ func Main() {
 	}
 
 	// parse script
-	s.parseScript(scriptBuffer)
+	err = s.parseScript(scriptBuffer)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Script error: %s\n", err.Error())
+		os.Exit(-1)
+	}
 
 	if currentFileParameter >= flag.NArg() {
 		if *edit_inplace {
 			fmt.Fprintf(os.Stderr, "Warning: Option -i ignored\n")
 		}
-		s.input = bufio.NewReader(os.Stdin)
-		s.process()
-	} else {
+		s.input = newLineReader(os.Stdin)
+		quit = s.process()
+	} else if !*treat_files_as_seperate && !*edit_inplace {
+		// the files are one stream, $ is the last line of the last file
+		var files []io.Reader
 		for ; currentFileParameter < flag.NArg(); currentFileParameter++ {
+			inputFilename = flag.Arg(currentFileParameter)
+			f, err := os.Open(inputFilename)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error openint input file: %s.\n\n", inputFilename)
+				usage()
+				os.Exit(-1)
+			}
+			defer f.Close()
+			files = append(files, f)
+		}
+		s.input = newLineReader(files...)
+		quit = s.process()
+	} else {
+		for ; currentFileParameter < flag.NArg() && quit == nil; currentFileParameter++ {
 			inputFilename = flag.Arg(currentFileParameter)
 			// actually do the processing
 			s.inputFile, err = os.Open(inputFilename)
@@ -318,8 +470,9 @@ func Main() {
 				usage()
 				os.Exit(-1)
 			}
-			s.input = bufio.NewReader(s.inputFile)
+			s.input = newLineReader(s.inputFile)
 			var tempFilename string
+			var tempFile *os.File
 			if *edit_inplace {
 				tempFilename = inputFilename + ".tmp"
 				tmpc := 0
@@ -329,24 +482,26 @@ func Main() {
 					tempFilename = inputFilename + "-" + strconv.Itoa(tmpc) + ".tmp"
 					dir, _ = os.Stat(tempFilename)
 				}
-				f, err := os.Create(tempFilename)
+				tempFile, err = os.Create(tempFilename)
 				if err != nil {
 					s.inputFile.Close()
 					fmt.Fprintf(os.Stderr, "Error opening temp file file for inplace editing: %s\n", err.Error())
 					os.Exit(-1)
 				}
-				s.outputFile = f
+				s.output = tempFile
+				s.outputMissingNewLine = false
 			}
-			s.process()
+			// a quit still finishes this file, but no more are read
+			quit = s.process()
 			// done processing, close input file
 			s.inputFile.Close()
 			s.input = nil
 			if *edit_inplace {
-				s.outputFile.Seek(0, 0)
+				tempFile.Seek(0, 0)
 				// find out about
 				dir, err := os.Stat(inputFilename)
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error getting information about input file: %s %v\n", err)
+					fmt.Fprintf(os.Stderr, "Error getting information about input file: %s %v\n", inputFilename, err)
 					// os.Remove(tempFilename);
 					os.Exit(-1)
 				}
@@ -358,8 +513,8 @@ func Main() {
 					os.Exit(-1)
 				}
 
-				_, e := io.Copy(s.inputFile, s.outputFile)
-				s.outputFile.Close()
+				_, e := io.Copy(s.inputFile, tempFile)
+				tempFile.Close()
 				s.inputFile.Close()
 				if e != nil {
 					fmt.Fprintf(os.Stderr, "Error copying temp file back to input file: %s\nFull output is in %s", err.Error(), tempFilename)
@@ -369,4 +524,8 @@ func Main() {
 			}
 		}
 	}
+	s.closeWFiles()
+	if quit != nil && quit.exitCode != 0 {
+		os.Exit(quit.exitCode)
+	}
 }
@@ -26,11 +26,17 @@
 package sed
 
 import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestNewCmd(t *testing.T) {
-	pieces := []byte{'4', 'x', '5', 'o', '/', '0', '/', 'g'}
+	pieces := []byte{'4', 'k', '5', 'o', '/', '0', '/', 'g'}
 	c, err := NewCmd(nil, pieces)
 	if c != nil {
 		t.Error("1: Got a command when we shouldn't have " + c.String())
@@ -44,7 +50,7 @@ func TestNewCmd(t *testing.T) {
 	// s
 	pieces = []byte{'s', '/', 'o', '/', '0', '/', 'g'}
 	c, err = NewCmd(nil, pieces)
-	sc := c.(*s_cmd)
+	sc, _ := c.(*s_cmd)
 	if sc == nil {
 		t.Error("Didn't get a command that we expected")
 	} else if sc.regex != "o" && len(sc.replace) == 1 && sc.replace[0] == '0' && sc.nthOccurance == -1 {
@@ -57,7 +63,7 @@ func TestNewCmd(t *testing.T) {
 func TestNewDCmd(t *testing.T) {
 	pieces := []byte{'d', '/', 'o', '/', '0', '/', 'g'}
 	c, err := NewCmd(nil, pieces)
-	dc := c.(*d_cmd)
+	dc, _ := c.(*d_cmd)
 	if dc != nil {
 		t.Error("2: Got a command when we shouldn't have " + c.String())
 	}
@@ -69,7 +75,7 @@ func TestNewDCmd(t *testing.T) {
 
 	pieces = []byte{'d', '/', 'd'}
 	c, err = NewCmd(nil, pieces)
-	dc = c.(*d_cmd)
+	dc, _ = c.(*d_cmd)
 	if dc != nil {
 		t.Error("3: Got a command when we shouldn't have " + c.String())
 	}
@@ -81,7 +87,7 @@ func TestNewDCmd(t *testing.T) {
 
 	pieces = []byte{'d'}
 	c, err = NewCmd(nil, pieces)
-	dc = c.(*d_cmd)
+	dc, _ = c.(*d_cmd)
 	if dc == nil {
 		t.Error("Didn't get a d command that we expected")
 	} else if err != nil {
@@ -90,7 +96,7 @@ func TestNewDCmd(t *testing.T) {
 
 	pieces = []byte{'$', 'd'}
 	c, err = NewCmd(nil, pieces)
-	dc = c.(*d_cmd)
+	dc, _ = c.(*d_cmd)
 	if dc == nil {
 		t.Error("Didn't get a d command that we expected")
 	} else if err != nil {
@@ -99,7 +105,7 @@ func TestNewDCmd(t *testing.T) {
 
 	pieces = []byte{'4', '5', '7', 'd'}
 	c, err = NewCmd(nil, pieces)
-	dc = c.(*d_cmd)
+	dc, _ = c.(*d_cmd)
 	if dc == nil {
 		t.Error("Didn't get a d command that we expected")
 	} else if err != nil {
@@ -110,7 +116,7 @@ func TestNewDCmd(t *testing.T) {
 func TestNewNCmd(t *testing.T) {
 	pieces := []byte{'n', '/', 'o', '/', '0', '/', 'g'}
 	c, err := NewCmd(nil, pieces)
-	nc := c.(*n_cmd)
+	nc, _ := c.(*n_cmd)
 	if nc != nil {
 		t.Error("4: Got a command when we shouldn't have " + c.String())
 	}
@@ -125,7 +131,7 @@ func TestNewNCmd(t *testing.T) {
 
 	pieces = []byte{'n', '/', 'd'}
 	c, err = NewCmd(nil, pieces)
-	nc = c.(*n_cmd)
+	nc, _ = c.(*n_cmd)
 	if nc != nil {
 		t.Error("5: Got a command when we shouldn't have " + c.String())
 	}
@@ -137,7 +143,7 @@ func TestNewNCmd(t *testing.T) {
 
 	pieces = []byte{'n'}
 	c, err = NewCmd(nil, pieces)
-	nc = c.(*n_cmd)
+	nc, _ = c.(*n_cmd)
 	if nc == nil {
 		t.Error("Didn't get a n command that we expected")
 	} else if err != nil {
@@ -146,7 +152,7 @@ func TestNewNCmd(t *testing.T) {
 
 	pieces = []byte{'$', 'n'}
 	c, err = NewCmd(nil, pieces)
-	nc = c.(*n_cmd)
+	nc, _ = c.(*n_cmd)
 	if nc == nil {
 		t.Error("Didn't get a d command that we expected")
 	} else if err != nil {
@@ -155,7 +161,7 @@ func TestNewNCmd(t *testing.T) {
 
 	pieces = []byte{'4', '5', '7', 'n'}
 	c, err = NewCmd(nil, pieces)
-	nc = c.(*n_cmd)
+	nc, _ = c.(*n_cmd)
 	if nc == nil {
 		t.Error("Didn't get a n command that we expected")
 	} else if err != nil {
@@ -166,7 +172,7 @@ func TestNewNCmd(t *testing.T) {
 func TestNewPCmd(t *testing.T) {
 	pieces := []byte{'P', '/', 'o', '/', '0', '/', 'g'}
 	c, err := NewCmd(nil, pieces)
-	pc := c.(*p_cmd)
+	pc, _ := c.(*p_cmd)
 	if pc != nil {
 		t.Error("6: Got a command when we shouldn't have " + c.String())
 	}
@@ -181,7 +187,7 @@ func TestNewPCmd(t *testing.T) {
 
 	pieces = []byte{'P', '/', 'd'}
 	c, err = NewCmd(nil, pieces)
-	pc = c.(*p_cmd)
+	pc, _ = c.(*p_cmd)
 	if pc != nil {
 		t.Error("7: Got a command when we shouldn't have " + c.String())
 	}
@@ -193,7 +199,7 @@ func TestNewPCmd(t *testing.T) {
 
 	pieces = []byte{'P'}
 	c, err = NewCmd(nil, pieces)
-	pc = c.(*p_cmd)
+	pc, _ = c.(*p_cmd)
 	if pc == nil {
 		t.Error("Didn't get a p command that we expected")
 	} else if err != nil {
@@ -202,7 +208,7 @@ func TestNewPCmd(t *testing.T) {
 
 	pieces = []byte{'$', 'P'}
 	c, err = NewCmd(nil, pieces)
-	pc = c.(*p_cmd)
+	pc, _ = c.(*p_cmd)
 	if pc == nil {
 		t.Error("Didn't get a p command that we expected")
 	} else if err != nil {
@@ -211,7 +217,7 @@ func TestNewPCmd(t *testing.T) {
 
 	pieces = []byte{'4', '5', '7', 'P'}
 	c, err = NewCmd(nil, pieces)
-	pc = c.(*p_cmd)
+	pc, _ = c.(*p_cmd)
 	if pc == nil {
 		t.Error("Didn't get a p command that we expected")
 	} else if err != nil {
@@ -222,37 +228,37 @@ func TestNewPCmd(t *testing.T) {
 func TestNewQCmd(t *testing.T) {
 	pieces := []byte{'q', '/', 'o', '/', '0', '/', 'g'}
 	c, err := NewCmd(nil, pieces)
-	qc := c.(*q_cmd)
+	qc, _ := c.(*q_cmd)
 	if err == nil {
 		t.Error("Didn't get an error we expected")
 	} else {
 		checkString(t, "Expected: Wrong number of parameters for command", "Wrong number of parameters for command", err.Error())
 	}
 
-	pieces = []byte{'q', '/', 'q'}
+	pieces = []byte{'q', ' ', 'q'}
 	c, err = NewCmd(nil, pieces)
-	qc = c.(*q_cmd)
+	qc, _ = c.(*q_cmd)
 	if qc != nil {
 		t.Error("9: Got a command when we shouldn't have " + c.String())
 	}
 	if err == nil {
 		t.Error("Didn't get an error we expected")
 	} else {
-		checkString(t, "Expected: strconv.ParseInt: parsing \"q\": invalid syntax", "strconv.ParseInt: parsing \"q\": invalid syntax", err.Error())
+		checkString(t, "Expected: Wrong number of parameters for command", "Wrong number of parameters for command", err.Error())
 	}
 
 	pieces = []byte{'q'}
 	c, err = NewCmd(nil, pieces)
-	qc = c.(*q_cmd)
+	qc, _ = c.(*q_cmd)
 	if qc == nil {
 		t.Error("Didn't get a q command that we expected")
 	} else if err != nil {
 		t.Error("Got an error we didn't expect: " + err.Error())
 	}
 
-	pieces = []byte{'q', '/', '1'}
+	pieces = []byte{'q', ' ', '1'}
 	c, err = NewCmd(nil, pieces)
-	qc = c.(*q_cmd)
+	qc, _ = c.(*q_cmd)
 	if qc == nil {
 		t.Error("Didn't get a q command that we expected")
 	} else if err != nil {
@@ -261,7 +267,7 @@ func TestNewQCmd(t *testing.T) {
 
 	pieces = []byte{'$', 'q'}
 	c, err = NewCmd(nil, pieces)
-	qc = c.(*q_cmd)
+	qc, _ = c.(*q_cmd)
 	if qc == nil {
 		t.Error("Didn't get a q command that we expected")
 	} else if err != nil {
@@ -270,7 +276,7 @@ func TestNewQCmd(t *testing.T) {
 
 	pieces = []byte{'4', '5', '7', 'q'}
 	c, err = NewCmd(nil, pieces)
-	qc = c.(*q_cmd)
+	qc, _ = c.(*q_cmd)
 	if qc == nil {
 		t.Error("Didn't get a d command that we expected")
 	} else if err != nil {
@@ -306,9 +312,656 @@ func TestProcessLine(t *testing.T) {
 	checkString(t, "bad global s command", "g0od", string(_s.patternSpace))
 }
 
+func TestBranch(t *testing.T) {
+	checkString(t, "t loop", "y\n", runScript(t, ":a\ns/x//\nta", "xxxy\n"))
+	checkString(t, "b to label", "abc\n", runScript(t, "b end\ns/a/X/\n:end", "abc\n"))
+	checkString(t, "b to end", "abc\n", runScript(t, "b\ns/a/X/", "abc\n"))
+	checkString(t, "T taken", "cb\n", runScript(t, "s/a/A/\nT\ns/b/B/", "cb\n"))
+	checkString(t, "T not taken", "AB\n", runScript(t, "s/a/A/\nT\ns/b/B/", "ab\n"))
+
+	s := new(Sed)
+	s.Init()
+	err := s.parseScript([]byte("p\nb nowhere"))
+	if !errors.Is(err, UndefinedLabel) {
+		t.Errorf("Expected an undefined label error, got %v", err)
+	} else {
+		checkString(t, "undefined label line", "Branch to an undefined label -> 2:1: b nowhere", err.Error())
+	}
+	s = new(Sed)
+	s.Init()
+	if err = s.parseScript([]byte(":a\n:a")); !errors.Is(err, DuplicateLabel) {
+		t.Errorf("Expected a duplicate label error, got %v", err)
+	}
+}
+
+func TestProgramOrder(t *testing.T) {
+	// a is matched where it appears in the script, not after the script ran
+	checkString(t, "a before s", "y\nhit\n", runScript(t, "/x/a hit\ns/x/y/", "x\n"))
+	checkString(t, "a after s", "y\n", runScript(t, "s/x/y/\n/x/a hit", "x\n"))
+	checkString(t, "branch over a", "x\n", runScript(t, "b\na hit", "x\n"))
+
+	s := new(Sed)
+	s.Init()
+	if err := s.parseScript([]byte("s/a/b/\n:top\np\nb top\nb")); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.program) != 5 {
+		t.Fatalf("Expected 5 instructions, got %d", len(s.program))
+	}
+	checkInt(t, s.program[3].(*b_cmd).target, 1, "b top target")
+	checkInt(t, s.program[4].(*b_cmd).target, 5, "b without a label target")
+}
+
+func TestBlocks(t *testing.T) {
+	checkString(t, "block", "a\na\n\nb\nb\n", runScript(t, "/./ {\n    p\n}", "a\n\nb\n"))
+	checkString(t, "block on one line", "a\n\nb\n", runScript(t, "/./ { p; d }", "a\n\nb\n"))
+	checkString(t, "negated block", "a\na\nxb\n", runScript(t, "/x/!{p}", "a\nxb\n"))
+	checkString(t, "nested blocks", "1\n2\n3\n3\n4\n", runScript(t, "2,3{\n/3/{p}\n}", "1\n2\n3\n4\n"))
+	checkString(t, "b closing a block", "x\nb!\n", runScript(t, "/a/{s/a/x/;b};s/$/!/", "a\nb\n"))
+	checkString(t, "t closing a block", "x\nb\n", runScript(t, "/a/{s/a/x/;t}", "a\nb\n"))
+	checkString(t, "label closing a block", "a\n", runScript(t, "{b end};s/a/x/;:end", "a\n"))
+
+	s := new(Sed)
+	s.Init()
+	if err := s.parseScript([]byte("p\n/x/{\np")); !errors.Is(err, UnterminatedBlock) {
+		t.Errorf("Expected an unmatched { error, got %v", err)
+	} else {
+		checkString(t, "unmatched { line", "Unmatched { without a closing } -> 2:1: /x/{", err.Error())
+	}
+	s = new(Sed)
+	s.Init()
+	if err := s.parseScript([]byte("{p}\n}")); !errors.Is(err, UnexpectedBlockEnd) {
+		t.Errorf("Expected an unexpected } error, got %v", err)
+	}
+}
+
+func TestScriptParsing(t *testing.T) {
+	tests := []struct {
+		script, input, expected string
+	}{
+		{"s/a;b/c/", "a;b\n", "c\n"},
+		{"s|/usr|/opt|", "/usr/bin\n", "/opt/bin\n"},
+		{"s/a\\/b/c/", "a/b\n", "c\n"},
+		{"s/[/;]/X/g", "a/b;c\n", "aXbXc\n"},
+		{"\\%a/b%d", "a/b\nc\n", "c\n"},
+		{"s/a/b/ ; s/b/c/", "a\n", "c\n"},
+		{"/x/{s/x/y/}", "x\n", "y\n"},
+		{"1a foo; p", "x\n", "x\nfoo; p\n"},
+		{"1a\\\n  foo\\\nbar", "x\n", "x\n  foo\nbar\n"},
+		{"1i\\  foo", "x\n", "  foo\nx\n"},
+		{"p # print it\n# comment;p\n", "x\n", "x\nx\n"},
+	}
+	for _, test := range tests {
+		checkString(t, test.script, test.expected, runScript(t, test.script, test.input))
+	}
+
+	c, err := NewCmd(nil, []byte("r /tmp/a file; p"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkString(t, "r filename", "/tmp/a file; p", c.(*r_cmd).filename)
+
+	s := new(Sed)
+	s.Init()
+	err = s.parseScript([]byte("p\n  s/a/b"))
+	if !errors.Is(err, UnterminatedRegularExpression) {
+		t.Errorf("Expected an unterminated regular expression error, got %v", err)
+	} else {
+		checkString(t, "error column", "Unterminated regular expression -> 2:7:   s/a/b", err.Error())
+	}
+
+	for _, script := range []string{"a", "1i  ", "c\np", "$a \t"} {
+		if err := s.parseScript([]byte(script)); !errors.Is(err, MissingText) {
+			t.Errorf("%q: Expected a missing text error, got %v", script, err)
+		}
+	}
+	checkString(t, "a with an empty line", "x\n\n", runScript(t, "a\\\n", "x\n"))
+}
+
+func TestAddressRanges(t *testing.T) {
+	input := "a\nBEGIN\nb\nEND\nc\nBEGIN\nd\nEND\ne\n"
+	tests := []struct {
+		script, expected string
+	}{
+		{"/BEGIN/,/END/d", "a\nc\ne\n"},
+		{"/BEGIN/,/END/!d", "BEGIN\nb\nEND\nBEGIN\nd\nEND\n"},
+		{"3,/END/d", "a\nBEGIN\nc\nBEGIN\nd\nEND\ne\n"},
+		{"/c/,7d", "a\nBEGIN\nb\nEND\nEND\ne\n"},
+		{"/END/,2d", "a\nBEGIN\nb\nc\nBEGIN\nd\ne\n"},
+		{"/c/,$d", "a\nBEGIN\nb\nEND\n"},
+		{"4,2d", "a\nBEGIN\nb\nc\nBEGIN\nd\nEND\ne\n"},
+		// the end isn't checked on the line that starts the range
+		{"/END/,/END/d", "a\nBEGIN\nb\ne\n"},
+		// but from line 0 it can end on line 1
+		{"0,/a/d", "BEGIN\nb\nEND\nc\nBEGIN\nd\nEND\ne\n"},
+		{"0,/END/d", "c\nBEGIN\nd\nEND\ne\n"},
+	}
+	for _, test := range tests {
+		checkString(t, test.script, test.expected, runScript(t, test.script, input))
+	}
+
+	s := new(Sed)
+	s.Init()
+	for _, script := range []string{"0p", "0,3p", "0,$p", "0!p"} {
+		if err := s.parseScript([]byte(script)); !errors.Is(err, InvalidLineZero) {
+			t.Errorf("%q: Expected a line 0 error, got %v", script, err)
+		}
+	}
+}
+
+func TestLastLine(t *testing.T) {
+	checkString(t, "$d", "a\nb\n", runScript(t, "$d", "a\nb\nc\n"))
+	checkString(t, "$!d", "c\n", runScript(t, "$!d", "a\nb\nc\n"))
+	checkString(t, "$a", "a\nb\nfooter\n", runScript(t, "$a footer", "a\nb\n"))
+	checkString(t, "range to $", "a\n", runScript(t, "/b/,$d", "a\nb\nc\n"))
+	checkString(t, "range from $", "a\nb\n", runScript(t, "$,/a/d", "a\nb\nc\n"))
+
+	// $ is the last line of the last input that has any lines
+	r := newLineReader(strings.NewReader("a\nb\n"), strings.NewReader("c\n"), strings.NewReader(""))
+	var last []string
+	for line, ok := r.readLine(); ok; line, ok = r.readLine() {
+		if r.isLast() {
+			last = append(last, string(line))
+		}
+	}
+	checkString(t, "last line of several inputs", "c", strings.Join(last, ","))
+}
+
+func TestLineReader(t *testing.T) {
+	long := strings.Repeat("x", 1<<20)
+	checkString(t, "long line", long+"\n"+long+"\n", runScript(t, "p", long+"\n"))
+
+	// output for a last line without a newline doesn't get one either
+	checkString(t, "missing newline", "a\na", runScript(t, "p", "a"))
+	checkString(t, "missing newline and a", "a\nfoo\n", runScript(t, "a foo", "a"))
+	checkString(t, "missing newline and i", "foo\na", runScript(t, "i foo", "a"))
+	checkString(t, "missing newline on last line", "b", runScript(t, "$!d", "a\nb"))
+
+	r := newLineReader(strings.NewReader("a"), strings.NewReader("b\n"))
+	var lines []string
+	for line, ok := r.readLine(); ok; line, ok = r.readLine() {
+		lines = append(lines, string(line))
+	}
+	checkString(t, "lines of several inputs", "a,b", strings.Join(lines, ","))
+
+	boom := errors.New("boom")
+	r = newLineReader(io.MultiReader(strings.NewReader("a\n"), iotest.ErrReader(boom)))
+	if line, ok := r.readLine(); !ok || string(line) != "a" {
+		t.Errorf("Expected to read a, got %q", line)
+	}
+	if _, ok := r.readLine(); ok {
+		t.Error("Expected no more lines after a read error")
+	}
+	if r.err != boom {
+		t.Errorf("Expected the read error, got %v", r.err)
+	}
+}
+
+func TestYCmd(t *testing.T) {
+	checkString(t, "ascii", "xyz\n", runScript(t, "y/abc/xyz/", "abc\n"))
+	checkString(t, "accents", "resume cafe\n", runScript(t, "y/éè/ee/", "résumè café\n"))
+	checkString(t, "cjk", "一二三\n", runScript(t, "y/123/一二三/", "123\n"))
+	checkString(t, "delimiter", "a|b/\n", runScript(t, "y|/\\||\\|/|", "a/b|\n"))
+	checkString(t, "newline", "b\nb\n", runScript(t, "y/a/\\n/", "bab\n"))
+	checkString(t, "backslash", "x\\\n", runScript(t, "y/\\\\x/x\\\\/", "\\x\n"))
+	checkString(t, "invalid utf-8", "x\xff\n", runScript(t, "y/a/x/", "a\xff\n"))
+
+	s := new(Sed)
+	s.Init()
+	err := s.parseScript([]byte("p\ny/abc/xy/"))
+	if !errors.Is(err, YStringsDifferentLength) {
+		t.Errorf("Expected a y length error, got %v", err)
+	} else {
+		checkString(t, "y length error", "The strings for y have a different number of characters: 3 in the source, 2 in the destination -> 2:2: y/abc/xy/", err.Error())
+	}
+}
+
+func TestNextLine(t *testing.T) {
+	checkString(t, "n", "1\n3\n5\n", runScript(t, "n;d", "1\n2\n3\n4\n5\n"))
+	checkString(t, "n counts lines", "1\n3\n4\n", runScript(t, "n;2d", "1\n2\n3\n4\n"))
+	checkString(t, "n on the last line", "a\n", runScript(t, "n;s/a/X/", "a\n"))
+	checkString(t, "n resets t", "A\nb-no\n", runScript(t, "s/a/A/;n;tx;s/$/-no/;b;:x;s/$/-yes/", "a\nb\n"))
+	checkString(t, "n writes appended text", "1\nfoo\n2\n", runScript(t, "1a foo\nn", "1\n2\n"))
+	checkString(t, "$!N", "1-2\n3-4\n5\n", runScript(t, "$!N;s/\\n/-/", "1\n2\n3\n4\n5\n"))
+	checkString(t, "N counts lines", "1\n2\n", runScript(t, "N;3,4d", "1\n2\n3\n4\n"))
+	checkString(t, "join lines", "a b c\n", runScript(t, ":a;N;$!ba;s/\\n/ /g", "a\nb\nc\n"))
+
+	// GNU sed prints the pattern space when N runs out of input, POSIX doesn't
+	checkString(t, "N on the last line", "1-2\n3\n", runScript(t, "N;s/\\n/-/", "1\n2\n3\n"))
+	*posix = true
+	defer func() { *posix = false }()
+	checkString(t, "N on the last line with -posix", "1-2\n", runScript(t, "N;s/\\n/-/", "1\n2\n3\n"))
+}
+
+func TestDCmd(t *testing.T) {
+	checkString(t, "D without a newline", "2\n", runScript(t, "1D", "1\n2\n"))
+	checkString(t, "$!N;P;D", "1\n2\n3\n4\n5\n", runScript(t, "$!N;P;D", "1\n2\n3\n4\n5\n"))
+	checkString(t, "D skips the rest of the script", "1\n2\n", runScript(t, "$!N;P;D;s/^/x/", "1\n2\n"))
+	checkString(t, "last two lines", "4\n5\n", runScript(t, "$!N;$!D", "1\n2\n3\n4\n5\n"))
+	checkString(t, "remove repeated a lines", "a\nb\na\n", runScript(t, "$!N;/^a\\na$/!P;D", "a\na\nb\na\na\n"))
+	checkString(t, "empty lines", "a\n\nb\n\n\nc\n", runScript(t, "$!N;P;D", "a\n\nb\n\n\nc\n"))
+	checkString(t, "appended text waits for the next read", "x1\nA\nx2\nx3\nA\nA\n", runScript(t, "$!N;s/^/x/;a A\nP;D", "1\n2\n3\n"))
+	checkString(t, "D restarts without reading", "1\n2\n3\n", runScript(t, "1{N;N};P;D", "1\n2\n3\n"))
+}
+
+func TestHoldSpace(t *testing.T) {
+	in := "a\nb\nc\n"
+	checkString(t, "tac", "c\nb\na\n", runScript(t, "1!G;h;$!d", in))
+	checkString(t, "x", "\na\nb\n", runScript(t, "x", in))
+	checkString(t, "x;G", "\na\na\nb\nb\nc\n", runScript(t, "x;G", in))
+	checkString(t, "H appends to the hold space", "\na\nb\nc\n", runScript(t, "H;$!d;x", in))
+	checkString(t, "H;x", "-a\na-b\nb-c\n", runScript(t, "H;x;s/\\n/-/g", in))
+	checkString(t, "h copies", "b\na\nb\nb\nc\nc\n", runScript(t, "h;s/a/b/;G", in))
+	checkString(t, "g", "\n\n\n", runScript(t, "g", in))
+	checkString(t, "swap the last two lines", "b\nc\n", runScript(t, "$!{h;d};x;G", in))
+}
+
+func TestReplacement(t *testing.T) {
+	checkString(t, "group", "[foo]\n", runScript(t, "s/\\(foo\\)/[\\1]/", "foo\n"))
+	checkString(t, "&", "fooo\n", runScript(t, "s/o/&&/", "foo\n"))
+	checkString(t, "$ is literal", "f$1$1\n", runScript(t, "s/o/$1/g", "foo\n"))
+	checkString(t, "\\0 and groups", "offoo\n", runScript(t, "s/\\(f\\)\\(o\\)/\\2\\1\\0/", "foo\n"))
+	checkString(t, "\\n", "f\no\n", runScript(t, "s/o/\\n/", "foo\n"))
+	checkString(t, "escaped newline", "f\no\n", runScript(t, "s/o/\\\n/", "foo\n"))
+	checkString(t, "\\&", "fa&bo\n", runScript(t, "s/o/a\\&b/", "foo\n"))
+	checkString(t, "& as the delimiter", "fx&yo\n", runScript(t, "s&o&x\\&y&", "foo\n"))
+	checkString(t, "\\\\", "f\\o\n", runScript(t, "s/o/\\\\/", "foo\n"))
+	checkString(t, "unmatched group", "<>oo\n", runScript(t, "s/\\(x\\)\\?f/<\\1>/", "foo\n"))
+	checkString(t, "nth occurrence", "fo[o]\n", runScript(t, "s/o/[&]/2", "foo\n"))
+	checkString(t, "empty matches", "-f-o-o-\n", runScript(t, "s/x*/-/g", "foo\n"))
+
+	s := new(Sed)
+	s.Init()
+	err := s.parseScript([]byte("s/\\(a\\)/\\2/"))
+	if !errors.Is(err, InvalidReference) {
+		t.Errorf("Expected an invalid reference error, got %v", err)
+	}
+}
+
+func TestBasicRegex(t *testing.T) {
+	checkString(t, "group and interval", "<ab>\n", runScript(t, "s/\\(ab\\)\\{2\\}/<\\1>/", "abab\n"))
+	checkString(t, "interval without a minimum", "Xab\n", runScript(t, "s/a\\{,2\\}/X/", "aaab\n"))
+	checkString(t, "ERE characters are literal", "X\n", runScript(t, "s/a+?{}()|b/X/", "a+?{}()|b\n"))
+	checkString(t, "\\+ and \\?", "X+Y\n", runScript(t, "s/a\\+/X/;s/bc\\?/Y/", "aa+b\n"))
+	checkString(t, "\\|", "XX\n", runScript(t, "s/a\\|b/X/g", "ab\n"))
+	checkString(t, "leading *", "aXb\n", runScript(t, "s/*/X/", "a*b\n"))
+	checkString(t, "* after ^", "Xb\n", runScript(t, "s/^*/X/", "*b\n"))
+	checkString(t, "* after \\(", "aXb\n", runScript(t, "s/\\(*\\)/X/", "a*b\n"))
+	checkString(t, "^ and $ inside", "X\n", runScript(t, "s/a^b$c/X/", "a^b$c\n"))
+	checkString(t, "$ before \\|", "aX\n", runScript(t, "s/b$\\|x/X/", "ab\n"))
+	checkString(t, "leftmost longest", "<xyz>\n", runScript(t, "s/x\\|xy\\|xyz/<&>/", "xyz\n"))
+	checkString(t, "bracket with ]", "aXb\n", runScript(t, "s/[]]/X/", "a]b\n"))
+	checkString(t, "bracket with -", "XXb\n", runScript(t, "s/[a-]/X/g", "a-b\n"))
+	checkString(t, "character class", "aXb\n", runScript(t, "s/[[:punct:]]/X/", "a.b\n"))
+	checkString(t, "equivalence class", "Xb\n", runScript(t, "s/[[=a=]]/X/", "ab\n"))
+	checkString(t, "\\ in a bracket", "aXb\n", runScript(t, "s/[\\\\]/X/", "a\\b\n"))
+	checkString(t, "\\n in a bracket", "aXb\n", runScript(t, "N;s/[\\n]/X/", "a\nb\n"))
+	checkString(t, ". matches a newline", "X\n", runScript(t, "N;s/a.b/X/", "a\nb\n"))
+	checkString(t, "$ is the end of the pattern space", "a\nb\n", runScript(t, "N;s/a$/X/", "a\nb\n"))
+	checkString(t, "word boundaries", "ab Xd\n", runScript(t, "s/\\<c/X/", "ab cd\n"))
+	checkString(t, "word start", "Yhello Yworld\n", runScript(t, "s/\\</Y/g", "hello world\n"))
+	checkString(t, "word end", "helloX worldX\n", runScript(t, "s/\\>/X/g", "hello world\n"))
+	checkString(t, "word start inside a word", "abc\n", runScript(t, "s/\\<b/X/", "abc\n"))
+	checkString(t, "word end before a word", "ab cd\n", runScript(t, "s/ \\>/X/", "ab cd\n"))
+	checkString(t, "word start and end", "a [b] c\n", runScript(t, "s/\\<.\\>/[&]/2", "a b c\n"))
+	checkString(t, "word start and end around words", "a X\n", runScript(t, "s/\\<b[a-z]*\\>/X/", "a bcd\n"))
+	checkString(t, "interval over 1000", "Xa\n", runScript(t, "s/a\\{1001\\}/X/", strings.Repeat("a", 1002)+"\n"))
+	checkString(t, "interval over 1000 not reached", strings.Repeat("a", 1000)+"\n", runScript(t, "s/a\\{1001,\\}/X/", strings.Repeat("a", 1000)+"\n"))
+	checkString(t, "interval up to over 1000", "X\n", runScript(t, "s/\\(ab\\)\\{1,1500\\}/X/", strings.Repeat("ab", 1200)+"\n"))
+
+	// where the next character has to be a word character \< and \> are
+	// just \b, and long lines don't need backtracking
+	for re, backtracks := range map[string]bool{`\<A.*z\>`: false, `\<\w`: false, `\<.`: true, `.\>`: true, `\(\<\)a`: true} {
+		m, err := compileRegex([]byte(re), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := m.(*backtracker); ok != backtracks {
+			t.Errorf("%s: backtracks is %v", re, ok)
+		}
+	}
+	long := "A" + strings.Repeat("b", 4000000) + "z\n"
+	checkString(t, "long line", "X\n", runScript(t, "s/\\<A.*z\\>/X/", long))
+	checkString(t, "address", "b\n", runScript(t, "/a\\.b/d", "a.b\nb\n"))
+
+	for _, re := range []string{`a\{`, `a\{2,1\}`, `\(a`, `a\)`, `[[:foo:]]`, `[b-a]`, `\{1\}`} {
+		if _, err := compileRegex([]byte(re), 0); err == nil {
+			t.Errorf("Expected an error compiling %s", re)
+		}
+	}
+}
+
+func TestExtendedRegex(t *testing.T) {
+	*extended_regexp = true
+	defer func() { *extended_regexp = false }()
+	checkString(t, "group and +", "<b>\n", runScript(t, "s/(a|b)+/<\\1>/", "ab\n"))
+	checkString(t, "interval", "Xb\n", runScript(t, "s/a{2}/X/", "aab\n"))
+	checkString(t, "? and empty alternative", "Xb\n", runScript(t, "s/(|a)a?/X/", "aab\n"))
+	checkString(t, "escaped operators are literal", "X\n", runScript(t, "s/\\(a\\|\\+\\{/X/", "(a|+{\n"))
+	checkString(t, "^ and $ are always anchors", "a^b$c\n", runScript(t, "s/a^b$c/X/", "a^b$c\n"))
+	checkString(t, "address", "b\n", runScript(t, "/a+b/d", "aab\nb\n"))
+
+	for _, re := range []string{`*a`, `+a`, `{1}`, `a{`, `a{x`, `(a`, `a)`, `^*`, `(*a)`, `x|*a`} {
+		if _, err := compileRegex([]byte(re), 0); err == nil {
+			t.Errorf("Expected an error compiling %s", re)
+		}
+	}
+}
+
+func TestBackReference(t *testing.T) {
+	checkString(t, "repeated word", "<the> cat\n", runScript(t, "s/\\(..*\\) \\1/<\\1>/", "the the cat\n"))
+	checkString(t, "duplicate lines", "a\nb\nc\n", runScript(t, "$!N;/^\\(.*\\)\\n\\1$/!P;D", "a\na\nb\nb\nb\nc\n"))
+	checkString(t, "global", "[abc] [xy]\n", runScript(t, "s/\\([a-z]*\\)\\1/[\\1]/g", "abcabc xyxy\n"))
+	checkString(t, "longest", "<aa>\n", runScript(t, "s/\\(a*\\)\\1/<\\1>/", "aaaa\n"))
+	checkString(t, "longest through alternatives", "<a|bab>\n", runScript(t, "s/\\(a\\|ab\\)\\(c\\|bab\\)/<\\1|\\2>/", "abab\n"))
+	checkString(t, "word boundaries", "D D D\n", runScript(t, "s/\\b\\(\\w\\)\\1\\b/D/g", "xx yy zz\n"))
+	checkString(t, "address", "pal:abcba\n", runScript(t, "/^\\(.\\)\\(.\\).\\2\\1$/s/^/pal:/", "abcba\n"))
+	checkString(t, "unmatched group doesn't match", "aa\n", runScript(t, "s/\\(b\\)*a\\1/X/", "aa\n"))
+
+	checkString(t, "empty repeat sets the group", "<>xx\n", runScript(t, "s/\\(a*\\)*\\1/<&>/", "xx\n"))
+	checkString(t, "empty repeat after a match", "<a>bab\n", runScript(t, "s/\\(a*\\)*\\1/<&>/", "abab\n"))
+	checkString(t, "empty repeat at the end", "<a>bc\n", runScript(t, "s/\\(a*\\)*\\1/<&>/", "abc\n"))
+
+	*backtrack_limit = 1000
+	defer func() { *backtrack_limit = 1000000 }()
+	re, err := compileRegex([]byte("\\(.*\\)\\1x"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = re.match([]byte(strings.Repeat("a", 30) + "bx")); !errors.Is(err, BacktrackLimitExceeded) {
+		t.Errorf("Expected the backtrack limit to be exceeded, got %v", err)
+	}
+	// positions where no match can start don't use up the steps
+	long := strings.Repeat("b", 100000) + "\n"
+	checkString(t, "long line", long, runScript(t, "s/\\(a\\)\\1/X/", long))
+	long = strings.Repeat("a", 20000) + "\n"
+	checkString(t, "long line that can't match", long, runScript(t, "s/\\(.*\\)\\1x/X/", long))
+
+	// a long match doesn't run out of stack
+	*backtrack_limit = 100000000
+	long = strings.Repeat("a", 300000) + "\n"
+	checkString(t, "long match", "X\n", runScript(t, "s/^\\(.*\\)\\1$/X/", long))
+	re, err = compileRegex([]byte("^\\(\\(a\\)*\\)\\1$"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = re.match([]byte(long)); !errors.Is(err, BacktrackLimitExceeded) {
+		t.Errorf("Expected the backtrack limit to be exceeded, got %v", err)
+	}
+}
+
+func TestSFlags(t *testing.T) {
+	checkString(t, "Ng", "aXXX\n", runScript(t, "s/a/X/2g", "aaaa\n"))
+	checkString(t, "gN", "aXXX\n", runScript(t, "s/a/X/g2", "aaaa\n"))
+	checkString(t, "p", "X\nX\nb\n", runScript(t, "s/a/X/p", "a\nb\n"))
+	checkString(t, "p and ;", "X\nX\nX\n", runScript(t, "s/a/X/ p ;p", "a\n"))
+	checkString(t, "I", "XXX\n", runScript(t, "s/a/X/Ig", "aAa\n"))
+	checkString(t, "i with a bracket", "XAb\n", runScript(t, "s/[^a]/X/i", "bAb\n"))
+	checkString(t, "M ^", "a\nX\n", runScript(t, "N;s/^b/X/M", "a\nb\n"))
+	checkString(t, "M $", "X\nb\n", runScript(t, "N;s/a$/X/M", "a\nb\n"))
+	checkString(t, "M .", "a\nb\n", runScript(t, "N;s/a.b/X/M", "a\nb\n"))
+	checkString(t, "M with back references", "aa\nb\n", runScript(t, "N;s/\\(a\\)$/\\1\\1/M", "a\nb\n"))
+	checkString(t, "I with back references", "X\n", runScript(t, "s/\\(a\\)\\1/X/I", "Aa\n"))
+	checkString(t, "e", "hi\n", runScript(t, "s/x*//e", "echo hi\n"))
+	checkString(t, "pe", "echo b\nb\n", runScript(t, "s/a/echo b/pe", "a\n"))
+	checkString(t, "ep", "b\nb\n", runScript(t, "s/a/echo b/ep", "a\n"))
+
+	name := filepath.Join(t.TempDir(), "w.txt")
+	checkString(t, "w", "X\nX\nb\nb\n", runScript(t, "s/a/X/w "+name+"\np", "a\nb\n"))
+	w, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkString(t, "w file", "X\n", string(w))
+
+	// the error is at the flag that is wrong
+	flagErrors := []struct {
+		script string
+		err    error
+		column int
+	}{
+		{"s/a/X/pp", RepeatedSCommandFlag, 8},
+		{"s/a/X/gg", RepeatedSCommandFlag, 8},
+		{"s/a/X/epe", RepeatedSCommandFlag, 9},
+		{"s/a/X/1 2", RepeatedSCommandFlag, 9},
+		{"s/a/X/0", ZeroSCommandOccurrence, 7},
+		{"s/a/X/w", MissingFilename, 7},
+		{"s/a/X/2/", InvalidSCommandFlag, 8},
+		{"s/a/X/q", InvalidSCommandFlag, 7},
+	}
+	for _, test := range flagErrors {
+		s := new(Sed)
+		s.Init()
+		err := s.parseScript([]byte(test.script))
+		var serr *scriptError
+		if !errors.Is(err, test.err) || !errors.As(err, &serr) {
+			t.Errorf("Expected %v parsing %s, got %v", test.err, test.script, err)
+		} else {
+			checkInt(t, serr.column, test.column, test.script+" column")
+		}
+	}
+}
+
+func TestNthOccurrence(t *testing.T) {
+	tests := []struct {
+		script, input, expected string
+	}{
+		{"s/aa/X/2", "aaaa", "aaX"},
+		{"s/aa/X/3", "aaaa", "aaaa"},
+		{"s/x*/-/2", "abc", "a-bc"},
+		{"s/x*/-/4", "abc", "abc-"},
+		{"s/x*/-/5", "abc", "abc"},
+		{"s/a*/X/2", "baaac", "bXc"},
+		{"s/a*/X/3", "baaac", "baaacX"},
+		{"s/a*/X/g", "baaac", "XbXcX"},
+		{"s/a*/X/2g", "baaac", "bXcX"},
+		{"s/o/0/2", "hello world", "hello w0rld"},
+		{"s/^a/X/2", "abcabc", "abcabc"},
+		{"s/b*/-/2", "abab", "a-ab"},
+		{"s/b*/-/3", "abab", "aba-"},
+		{"s/a/X/4", "aaa", "aaa"},
+		{"s/[a-z]*/<&>/2", "foo bar baz", "foo <bar> baz"},
+		{"s/[a-z]*/<&>/3", "foo bar baz", "foo bar <baz>"},
+		{"s/\\(x\\)\\(y\\)/\\2\\1/2", "xyxy", "xyyx"},
+		{"s/\\(a\\)\\1*/X/2", "abaab", "abXb"},
+		{"s/\\(x*\\)\\1/-/3", "abc", "ab-c"},
+	}
+	for _, test := range tests {
+		checkString(t, test.script+" on "+test.input, test.expected+"\n", runScript(t, test.script, test.input+"\n"))
+	}
+
+	// without g the search stops at the match being replaced
+	for _, src := range []string{"b", "\\(b\\)\\1*"} {
+		re, err := compileRegex([]byte(src), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		matches, err := re.findAllSubmatchIndex([]byte("bababab"), 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkInt(t, len(matches), 2, src+" matches")
+	}
+}
+
+func TestCaseConversion(t *testing.T) {
+	tests := []struct {
+		script, input, expected string
+	}{
+		{"s/_\\(.\\)/\\u\\1/g", "foo_bar_baz", "fooBarBaz"},
+		{"s/.*/\\L&/", "FooBar", "foobar"},
+		{"s/\\w\\+/\\u&/g", "foo bar", "Foo Bar"},
+		{"s/.*/\\U&\\E!/", "foo bar", "FOO BAR!"},
+		{"s/\\(hello\\) \\(world\\)/\\U\\1\\E \\2/", "hello world", "HELLO world"},
+		{"s/.*/\\L\\u&/", "HELLO", "Hello"},
+		{"s/.*/\\u\\L&/", "HELLO", "hello"},
+		{"s/\\(x*\\)\\(ab\\)/\\u\\1\\2/", "ab", "Ab"},
+		{"s/b/\\Ux\\lYz/", "abc", "aXyZc"},
+		{"s/\\(a\\)\\(b\\)/\\U\\1\\L\\2X/", "abc", "Abxc"},
+		{"s/\\([a-z]\\)\\([A-Z]\\)/\\1_\\l\\2/g", "fooBar", "foo_bar"},
+		{"s/.*/\\U&/", "ébène", "ÉBÈNE"},
+		{"s/.*/\\L\\u&/", "ÉCOLE", "École"},
+	}
+	for _, test := range tests {
+		checkString(t, test.script+" on "+test.input, test.expected+"\n", runScript(t, test.script, test.input+"\n"))
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	dir := t.TempDir()
+	noNewLine := filepath.Join(dir, "x")
+	lines := filepath.Join(dir, "lines")
+	if err := os.WriteFile(noNewLine, []byte("x"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(lines, []byte("l1\nl2"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	checkString(t, "r", "a\nxb\nx", runScript(t, "r "+noNewLine, "a\nb\n"))
+	checkString(t, "r a missing file", "a\nb\n", runScript(t, "r "+filepath.Join(dir, "missing"), "a\nb\n"))
+	checkString(t, "r and a in order", "a\nxfoo\nx", runScript(t, "r "+noNewLine+"\na foo\nr "+noNewLine, "a\n"))
+	checkString(t, "r before N", "l1\nl2x\na\nb\n", runScript(t, "1r "+lines+"\n1a x\n$!N", "a\nb\n"))
+	checkString(t, "R", "a\nl1\nb\nl2c\n", runScript(t, "R "+lines, "a\nb\nc\n"))
+	checkString(t, "R twice", "a\nl1\nl2b\n", runScript(t, "R "+lines+"\nR "+lines, "a\nb\n"))
+
+	s := new(Sed)
+	s.Init()
+	if err := s.parseScript([]byte("r")); !errors.Is(err, MissingFilename) {
+		t.Errorf("Expected a missing file name error, got %v", err)
+	}
+}
+
+func TestAppendQueue(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "lines")
+	if err := os.WriteFile(name, []byte("R1\nR2\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	in := "a\nb\n"
+	checkString(t, "i runs where it is", "a\nI\na\nb\n", runScript(t, "1{p;i I\n}", in))
+	checkString(t, "i isn't queued", "I\nX\nA\nb\n", runScript(t, "1a A\ns/a/X/\n1i I", in))
+	checkString(t, "a, r and R in script order", "a\nA\nR1\nR2\nR1\nB\nb\n",
+		runScript(t, "1{a A\nr "+name+"\nR "+name+"\na B\n}", in))
+	checkString(t, "n flushes the queue", "a\nA\nI\nb\n", runScript(t, "1{a A\nn;i I\n}", in))
+	checkString(t, "N flushes the queue", "A\na-b\n", runScript(t, "1a A\nN;s/\\n/-/", in))
+	checkString(t, "queue flushed when deleted", "A\nb\n", runScript(t, "1{a A\nd\n}", in))
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "w")
+	checkFile := func(msg, expected string) {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkString(t, msg, expected, string(b))
+	}
+	checkString(t, "w", "a\nb\n", runScript(t, "w "+name, "a\nb\n"))
+	checkFile("w", "a\nb\n")
+	runScript(t, "w "+name, "a\nb")
+	checkFile("w without a final newline", "a\nb")
+	runScript(t, "N;W "+name+"\ns/b/B/w "+name, "a\nb\n")
+	checkFile("W and s///w share a file", "a\na\nB\n")
+	runScript(t, "$!N;W "+name, "a\nb\nc")
+	checkFile("W on the last line", "a\nc")
+	runScript(t, "2w "+name, "a\n")
+	checkFile("w truncates even if it never writes", "")
+
+	s := new(Sed)
+	s.Init()
+	if err := s.parseScript([]byte("w")); !errors.Is(err, MissingFilename) {
+		t.Errorf("Expected a missing file name error, got %v", err)
+	}
+	for _, script := range []string{"w " + name, "s/a/b/w " + name} {
+		if c, err := NewCmd(nil, []byte(script)); c != nil || !errors.Is(err, NoSedForFile) {
+			t.Errorf("%s: Expected an error without a Sed, got %v", script, err)
+		}
+	}
+}
+
+func TestCCmd(t *testing.T) {
+	in := "a\nb\nc\nd\n"
+	checkString(t, "line", "a\nX\nc\nd\n", runScript(t, "2c X", in))
+	checkString(t, "every line", "X\nX\nX\nX\n", runScript(t, "c X", in))
+	checkString(t, "range", "a\nX\nd\n", runScript(t, "2,3c X", in))
+	checkString(t, "$", "a\nb\nc\nX\n", runScript(t, "$c X", in))
+	checkString(t, "range to $", "a\nX\n", runScript(t, "2,$c X", in))
+	checkString(t, "regex range", "a\nX\nd\n", runScript(t, "/b/,/c/c X", in))
+	checkString(t, "one line range", "a\nX\nc\nd\n", runScript(t, "2,1c X", in))
+	checkString(t, "negated range", "X\nb\nc\nX\n", runScript(t, "2,3!c X", in))
+	checkString(t, "range in a block", "a\nX\nX\nd\n", runScript(t, "2,3{c X\n}", in))
+	checkString(t, "range the input ends in", "a\n", runScript(t, "/b/,/z/c X", in))
+	checkString(t, "range past the input", "a\n", runScript(t, "2,5c X", "a\nb\nc\n"))
+	checkString(t, "range starting on the last line", "a\nb\nc\n", runScript(t, "/d/,/z/c X", in))
+}
+
+func TestQuit(t *testing.T) {
+	in := "a\nb\nc\n"
+	checkString(t, "q", "a\nb\n", runScript(t, "2q", in))
+	checkString(t, "Q", "a\n", runScript(t, "2Q", in))
+	checkString(t, "q flushes appended text", "a\nb\nX\n", runScript(t, "2{a X\nq\n}", in))
+	checkString(t, "Q drops appended text", "a\n", runScript(t, "2{a X\nQ\n}", in))
+	checkString(t, "q after the input", "a\nb\nc\n", runScript(t, "5q", in))
+
+	s := new(Sed)
+	s.Init()
+	if err := s.parseScript([]byte("2q5")); err != nil {
+		t.Fatal(err)
+	}
+	s.output = io.Discard
+	s.input = newLineReader(strings.NewReader(in))
+	quit := s.process()
+	if quit == nil {
+		t.Fatal("Didn't get the quit status we expected")
+	}
+	checkInt(t, quit.exitCode, 5, "exit code")
+	checkInt(t, s.lineNumber, 2, "lines read")
+}
+
+func TestLCmd(t *testing.T) {
+	checkString(t, "escapes", "a\\tb\\\\c\\001\\177\\303\\251$\n", runScript(t, "l;d", "a\tb\\c\001\177\u00e9"))
+	checkString(t, "newline", "a\\nb$\n", runScript(t, "N;l;d", "a\nb\n"))
+	checkString(t, "wrap", "0123\\\n4567\\\n89$\n", runScript(t, "l 5;d", "0123456789\n"))
+	checkString(t, "escapes aren't split", "a\\tb\\\n\\tc$\n", runScript(t, "l 5;d", "a\tb\tc\n"))
+	checkString(t, "no wrap", "0123456789$\n", runScript(t, "l 0;d", "0123456789\n"))
+	long := strings.Repeat("x", 100)
+	checkString(t, "normal output isn't wrapped", long+"\n", runScript(t, "", long+"\n"))
+}
+
+func TestEqlCmd(t *testing.T) {
+	checkString(t, "line numbers", "1\na\n2\nb\n", runScript(t, "=", "a\nb\n"))
+	checkString(t, "last line", "a\n2\nb\n", runScript(t, "$=", "a\nb\n"))
+	checkString(t, "after a line without a newline", "a\n1\na", runScript(t, "p;=", "a"))
+}
+
+// runScript runs script over input and returns everything written to the
+// output.
+func runScript(t *testing.T, script, input string) string {
+	s := new(Sed)
+	s.Init()
+	if err := s.parseScript([]byte(script)); err != nil {
+		t.Fatalf("%q: %v", script, err)
+	}
+	defer s.closeWFiles()
+	out, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	s.output = out
+	s.input = newLineReader(strings.NewReader(input))
+	s.process()
+	out.Seek(0, 0)
+	b, err := io.ReadAll(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
 func checkInt(t *testing.T, val, expected int, actual string) {
 	if expected != val {
-		t.Errorf("%s: '%d' != '%d'", val, expected, actual)
+		t.Errorf("%s: '%d' != '%d'", actual, expected, val)
 	}
 }
 
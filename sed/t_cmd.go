@@ -0,0 +1,74 @@
+//
+//  t_cmd.go
+//  sed
+//
+// Copyright (c) 2009 Geoffrey Clements
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+
+package sed
+
+import (
+	"fmt"
+)
+
+type t_cmd struct {
+	addr      *address
+	label     string
+	target    int
+	scriptPos int
+	inverse   bool
+}
+
+func (c *t_cmd) match(s *Sed) bool {
+	return c.addr.match(s)
+}
+
+func (c *t_cmd) String() string {
+	if c != nil {
+		name := 't'
+		if c.inverse {
+			name = 'T'
+		}
+		if c.addr != nil {
+			return fmt.Sprintf("{%c command label: %s addr:%s}", name, c.label, c.addr.String())
+		}
+		return fmt.Sprintf("{%c command label: %s}", name, c.label)
+	}
+	return fmt.Sprint("{t command}")
+}
+
+func (c *t_cmd) processLine(s *Sed) (bool, error) {
+	// t branches when a substitution has been made since the last input line
+	// was read or the last t was taken, T branches when one hasn't
+	if s.substituted != c.inverse {
+		s.pc = c.target
+	}
+	s.substituted = false
+	return false, nil
+}
+
+func NewTCmd(name byte, label []byte, addr *address) (*t_cmd, error) {
+	cmd := new(t_cmd)
+	cmd.addr = addr
+	cmd.inverse = name == 'T'
+	cmd.label = string(label)
+	return cmd, nil
+}
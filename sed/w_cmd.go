@@ -0,0 +1,96 @@
+//
+//  w_cmd.go
+//  sed
+//
+// Copyright (c) 2009 Geoffrey Clements
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+
+package sed
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// wfile is a file written by w, W or the w flag of s. Every command naming
+// the same file shares one wfile. Like the output, it holds back the
+// newline after a last line that didn't have one until more is written.
+type wfile struct {
+	name           string
+	f              *os.File
+	missingNewLine bool
+}
+
+// writeLine writes line followed by a newline, unless it is the end of the
+// input and the input didn't end with one.
+func (w *wfile) writeLine(s *Sed, line []byte, last bool) {
+	if w.missingNewLine {
+		w.f.Write(newLine)
+		w.missingNewLine = false
+	}
+	w.f.Write(line)
+	if last && s.input != nil && s.input.missingNewLine {
+		w.missingNewLine = true
+	} else {
+		w.f.Write(newLine)
+	}
+}
+
+type w_cmd struct {
+	addr             *address
+	file             *wfile
+	upToFirstNewLine bool
+}
+
+func (c *w_cmd) match(s *Sed) bool {
+	return c.addr.match(s)
+}
+
+func (c *w_cmd) String() string {
+	name := "w"
+	if c != nil && c.upToFirstNewLine {
+		name = "W"
+	}
+	if c != nil && c.addr != nil {
+		return fmt.Sprintf("{%s command filename:%s addr:%s}", name, c.file.name, c.addr.String())
+	}
+	return fmt.Sprintf("{%s command}", name)
+}
+
+func (c *w_cmd) processLine(s *Sed) (bool, error) {
+	if c.upToFirstNewLine {
+		if idx := bytes.IndexByte(s.patternSpace, '\n'); idx >= 0 {
+			c.file.writeLine(s, s.patternSpace[:idx], false)
+			return false, nil
+		}
+	}
+	c.file.writeLine(s, s.patternSpace, true)
+	return false, nil
+}
+
+func NewWCmd(name byte, file *wfile, addr *address) (*w_cmd, error) {
+	cmd := new(w_cmd)
+	cmd.addr = addr
+	cmd.file = file
+	cmd.upToFirstNewLine = name == 'W'
+	return cmd, nil
+}
@@ -0,0 +1,56 @@
+//
+//  x_cmd.go
+//  sed
+//
+// Copyright (c) 2009 Geoffrey Clements
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+
+package sed
+
+import (
+	"fmt"
+)
+
+type x_cmd struct {
+	addr *address
+}
+
+func (c *x_cmd) match(s *Sed) bool {
+	return c.addr.match(s)
+}
+
+func (c *x_cmd) String() string {
+	if c != nil && c.addr != nil {
+		return fmt.Sprintf("{x command addr:%s}", c.addr.String())
+	}
+	return fmt.Sprint("{Exchange the pattern space and hold space}")
+}
+
+func (c *x_cmd) processLine(s *Sed) (bool, error) {
+	s.patternSpace, s.holdSpace = s.holdSpace, s.patternSpace
+	return false, nil
+}
+
+func NewXCmd(addr *address) (*x_cmd, error) {
+	cmd := new(x_cmd)
+	cmd.addr = addr
+	return cmd, nil
+}
@@ -0,0 +1,108 @@
+//
+//  y_cmd.go
+//  sed
+//
+// Copyright (c) 2009 Geoffrey Clements
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//
+
+package sed
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+type y_cmd struct {
+	addr   *address
+	source []rune
+	dest   []rune
+	table  map[rune]rune
+}
+
+func (c *y_cmd) match(s *Sed) bool {
+	return c.addr.match(s)
+}
+
+func (c *y_cmd) String() string {
+	if c != nil {
+		if c.addr != nil {
+			return fmt.Sprintf("{y command addr:%s source:%s dest:%s}", c.addr.String(), string(c.source), string(c.dest))
+		}
+		return fmt.Sprintf("{y command source:%s dest:%s}", string(c.source), string(c.dest))
+	}
+	return fmt.Sprint("{y command}")
+}
+
+func (c *y_cmd) processLine(s *Sed) (bool, error) {
+	buf := new(bytes.Buffer)
+	line := s.patternSpace
+	for len(line) > 0 {
+		r, width := utf8.DecodeRune(line)
+		if to, ok := c.table[r]; ok && !(r == utf8.RuneError && width == 1) {
+			buf.WriteRune(to)
+		} else {
+			// bytes that aren't valid UTF-8 are left alone
+			buf.Write(line[0:width])
+		}
+		line = line[width:]
+	}
+	s.patternSpace = buf.Bytes()
+	return false, nil
+}
+
+// yRunes turns one of the strings of a y command into runes. \n is a
+// newline and a \ before any other character, including \ itself, is
+// dropped. The lexer has already unescaped the delimiter, apart from &.
+func yRunes(b []byte) []rune {
+	runes := make([]rune, 0, len(b))
+	for len(b) > 0 {
+		r, width := utf8.DecodeRune(b)
+		b = b[width:]
+		if r == '\\' && len(b) > 0 {
+			r, width = utf8.DecodeRune(b)
+			b = b[width:]
+			if r == 'n' {
+				r = '\n'
+			}
+		}
+		runes = append(runes, r)
+	}
+	return runes
+}
+
+func NewYCmd(source, dest []byte, addr *address) (*y_cmd, error) {
+	cmd := new(y_cmd)
+	cmd.addr = addr
+	cmd.source = yRunes(source)
+	cmd.dest = yRunes(dest)
+	if len(cmd.source) != len(cmd.dest) {
+		return nil, fmt.Errorf("%w: %d in the source, %d in the destination", YStringsDifferentLength, len(cmd.source), len(cmd.dest))
+	}
+	cmd.table = make(map[rune]rune, len(cmd.source))
+	for i, r := range cmd.source {
+		// if a character is repeated the first one wins
+		if _, ok := cmd.table[r]; !ok {
+			cmd.table[r] = cmd.dest[i]
+		}
+	}
+	return cmd, nil
+}